@@ -98,22 +98,22 @@ func httpGet(url string) ([]map[string]string, error) {
 func main() {
 	inputFile := ""
 	outputFile := ""
-	flag.StringVar(&inputFile, "input", inputFile, "input file containing a ChEMBL ID per line")
+	flag.StringVar(&inputFile, "input", inputFile, "input file containing a ChEMBL ID per line; read from stdin if empty")
 	flag.StringVar(&outputFile, "output", outputFile, "output file path")
 	flag.Parse()
 
-	if inputFile == "" {
-		fmt.Println("interactions file must be provided")
-		os.Exit(1)
-	}
-
-	file, err := os.Open(inputFile)
-	if err != nil {
-		panic(err)
+	var file io.ReadCloser = os.Stdin
+	if inputFile != "" {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			panic(err)
+		}
+		file = f
 	}
 	defer file.Close()
 
 	var out io.WriteCloser
+	var err error
 	if outputFile != "" {
 		outputFile, err = filepath.Abs(outputFile)
 		if err != nil {
@@ -29,10 +29,10 @@ type Meta struct {
 type Record struct {
 	ID                string             `json:"id,omitempty"`
 	GeneName          string             `json:"gene_name,omitempty"`
-	EntrezID          int32              `json:"entrez_id,omitempty"`
+	EntrezID          int64              `json:"entrez_id,omitempty"`
 	DrugName          string             `json:"drug_name,omitempty"`
 	ChemblID          string             `json:"chembl_id,omitempty"`
-	Publications      []int32            `json:"publications,omitempty"`
+	Publications      []int64            `json:"publications,omitempty"`
 	InteractionTypes  []string           `json:"interaction_types,omitempty"`
 	Sources           []string           `json:"sources,omitempty"`
 	Attributes        []Attribute        `json:"attributes,omitempty"`
@@ -46,11 +46,11 @@ type Attribute struct {
 }
 
 type InteractionClaim struct {
-	Source          string      `json:"source,omitempty"`
-	Drug            string      `json:"drug,omitempty"`
-	Gene            string      `json:"gene,omitempty"`
-	IntractionTypes []string    `json:"interaction_types,omitempty"`
-	Attributes      []Attribute `json:"attributes,omitempty"`
+	Source           string      `json:"source,omitempty"`
+	Drug             string      `json:"drug,omitempty"`
+	Gene             string      `json:"gene,omitempty"`
+	InteractionTypes []string    `json:"interaction_types,omitempty"`
+	Attributes       []Attribute `json:"attributes,omitempty"`
 }
 
 func main() {
@@ -0,0 +1,3255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/biostream/dgidb-transform/dgidb"
+	"github.com/biostream/dgidb-transform/unichem"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type Meta struct {
+	CurrentPage int32 `json:"current_page,omitempty"`
+	// PerPage     int32 `json:"per_page,omitempty"`
+	TotalPages int32 `json:"total_pages,omitempty"`
+	Links      struct {
+		Next     string `json:"next,omitempty"`
+		Previous string `json:"previous,omitempty"`
+	} `json:"links,omitempty"`
+}
+
+type Record struct {
+	ID                string             `json:"id,omitempty"`
+	GeneName          string             `json:"gene_name,omitempty"`
+	EntrezID          int64              `json:"entrez_id,omitempty"`
+	DrugName          string             `json:"drug_name,omitempty"`
+	ChemblID          string             `json:"chembl_id,omitempty"`
+	Publications      []int64            `json:"publications,omitempty"`
+	InteractionTypes  []string           `json:"interaction_types,omitempty"`
+	Sources           []string           `json:"sources,omitempty"`
+	Attributes        []Attribute        `json:"attributes,omitempty"`
+	InteractionClaims []InteractionClaim `json:"interaction_claims,omitempty"`
+}
+
+type Attribute struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	// Values lists every distinct value of an attribute merged by
+	// -normalize-attributes from entries that disagree; Value holds the
+	// first.
+	Values  []string `json:"values,omitempty"`
+	Sources []string `json:"sources,omitempty"`
+}
+
+type InteractionClaim struct {
+	Source           string      `json:"source,omitempty"`
+	Drug             string      `json:"drug,omitempty"`
+	Gene             string      `json:"gene,omitempty"`
+	InteractionTypes []string    `json:"interaction_types,omitempty"`
+	Attributes       []Attribute `json:"attributes,omitempty"`
+}
+
+// EnrichedRecord is an interaction Record together with the compound IDs
+// resolved for its drug and, with -enrich-genes, the IDs of its gene.
+type EnrichedRecord struct {
+	Record
+	Compound unichem.CompoundID `json:"compound"`
+	Gene     *GeneID            `json:"gene,omitempty"`
+
+	// seq is the number of the input record, which picks its -split part.
+	seq int64
+}
+
+// GeneID holds the identifiers MyGene.info maps an Entrez gene to.
+type GeneID struct {
+	Ensembl string `json:"ensembl,omitempty"`
+	HGNC    string `json:"hgnc,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+}
+
+// compoundFields returns the populated string fields of compound keyed by
+// their JSON name. AllSources is not included.
+func compoundFields(compound unichem.CompoundID) (map[string]string, error) {
+	body, err := json.Marshal(compound)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			fields[k] = s
+		}
+	}
+	return fields, nil
+}
+
+// recordWriter serializes resolved compounds. Implementations are not safe
+// for concurrent use.
+type recordWriter interface {
+	Write(rec EnrichedRecord) error
+	Flush() error
+}
+
+// jsonWriter writes newline delimited JSON, either bare CompoundIDs or, in
+// enrich mode, EnrichedRecords. With flat set source fields are named
+// <source>_id and enriched records carry them at the top level. With
+// keepFailed set a failed lookup of an input record is written as that
+// record plus its error instead of a CompoundID. With provenance set a bare
+// CompoundID also carries the sources and interaction types of its record.
+type jsonWriter struct {
+	enc        *json.Encoder
+	enrich     bool
+	flat       bool
+	keepFailed bool
+	provenance bool
+}
+
+// provenancedCompound is a CompoundID plus the DGIdb provenance of the
+// record it was resolved for.
+type provenancedCompound struct {
+	unichem.CompoundID
+	Sources          []string `json:"sources,omitempty"`
+	InteractionTypes []string `json:"interaction_types,omitempty"`
+}
+
+// failedRecord is an input record whose compound could not be resolved.
+type failedRecord struct {
+	Record
+	Error string `json:"error"`
+}
+
+func (w *jsonWriter) Write(rec EnrichedRecord) error {
+	if w.keepFailed && rec.Compound.Error != "" {
+		return w.enc.Encode(failedRecord{Record: rec.Record, Error: rec.Compound.Error})
+	}
+	if w.flat {
+		return w.writeFlat(rec)
+	}
+	if w.enrich {
+		return w.enc.Encode(rec)
+	}
+	return w.enc.Encode(w.compound(rec))
+}
+
+// compound returns the value written for rec outside enrich mode.
+func (w *jsonWriter) compound(rec EnrichedRecord) interface{} {
+	if w.provenance {
+		return provenancedCompound{CompoundID: rec.Compound, Sources: rec.Sources, InteractionTypes: rec.InteractionTypes}
+	}
+	return rec.Compound
+}
+
+func (w *jsonWriter) writeFlat(rec EnrichedRecord) error {
+	compound, err := jsonObject(w.compound(rec))
+	if err != nil {
+		return err
+	}
+	for name, v := range compound {
+		if name == "chembl" || isSourceField(name) {
+			delete(compound, name)
+			compound[name+"_id"] = v
+		}
+	}
+	if !w.enrich {
+		return w.enc.Encode(compound)
+	}
+
+	record, err := jsonObject(rec)
+	if err != nil {
+		return err
+	}
+	delete(record, "compound")
+	for name, v := range compound {
+		record[name] = v
+	}
+	return w.enc.Encode(record)
+}
+
+// jsonObject marshals v, which must encode as a JSON object, and returns
+// its fields.
+func jsonObject(v interface{}) (map[string]json.RawMessage, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &fields)
+	return fields, err
+}
+
+// isSourceField reports whether name is the CompoundID field of a known
+// source.
+func isSourceField(name string) bool {
+	for _, field := range unichem.KnownSources {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *jsonWriter) Flush() error {
+	return nil
+}
+
+// jsonpbWriter writes newline delimited JSON following the proto3 JSON
+// mapping of the messages in package dgidb, generated from dgidb.proto.
+// Each record is converted to its message through its JSON form, so a
+// field missing from dgidb.proto fails the write rather than being dropped.
+type jsonpbWriter struct {
+	w      io.Writer
+	enrich bool
+}
+
+func (w *jsonpbWriter) Write(rec EnrichedRecord) error {
+	var v interface{} = rec.Compound
+	var msg proto.Message = &dgidb.CompoundID{}
+	if w.enrich {
+		v = rec
+		msg = &dgidb.Record{}
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err = dec.Decode(&tree)
+	if err != nil {
+		return err
+	}
+	if w.enrich {
+		wrapIDLists(tree.(map[string]interface{})["compound"])
+	} else {
+		wrapIDLists(tree)
+	}
+	body, err = json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	err = protojson.Unmarshal(body, msg)
+	if err != nil {
+		return fmt.Errorf("converting to %s: %w", msg.ProtoReflect().Descriptor().FullName(), err)
+	}
+	body, err = protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// protojson varies its whitespace from build to build; compacting it
+	// keeps the output stable.
+	line := &bytes.Buffer{}
+	err = json.Compact(line, body)
+	if err != nil {
+		return err
+	}
+	line.WriteByte('\n')
+	_, err = w.w.Write(line.Bytes())
+	return err
+}
+
+// wrapIDLists rewrites the ids, connectivity and obsolete lists of a
+// decoded CompoundID as IDList messages, since proto3 map values cannot be
+// repeated.
+func wrapIDLists(compound interface{}) {
+	fields, ok := compound.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, field := range []string{"ids", "connectivity", "obsolete"} {
+		ids, ok := fields[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k, list := range ids {
+			ids[k] = map[string]interface{}{"ids": list}
+		}
+	}
+}
+
+func (w *jsonpbWriter) Flush() error {
+	return nil
+}
+
+// csvWriter writes one delimited row per compound, with a header row
+// naming the chembl column, each selected source and the error column.
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+func newCSVWriter(out io.Writer, comma rune, sources map[string]bool) *csvWriter {
+	ids := []string{}
+	for id := range sources {
+		ids = append(ids, id)
+	}
+	columns := []string{"chembl"}
+	for _, id := range unichem.SortSourceIDs(ids) {
+		columns = append(columns, unichem.KnownSources[id])
+	}
+	columns = append(columns, "error")
+
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	return &csvWriter{w: w, columns: columns}
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.w.Write(w.columns)
+}
+
+func (w *csvWriter) Write(rec EnrichedRecord) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	fields, err := compoundFields(rec.Compound)
+	if err != nil {
+		return err
+	}
+
+	row := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		row[i] = fields[c]
+	}
+	return w.w.Write(row)
+}
+
+func (w *csvWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// mapWriter writes a single JSON object mapping each ChEMBL ID to its
+// CompoundID. Entries are written as they arrive and a ChEMBL ID is only
+// written the first time it is seen; compounds without one are dropped.
+// With pretty set the object is indented. Close ends the object.
+type mapWriter struct {
+	w      io.Writer
+	pretty bool
+	seen   map[string]bool
+	begun  bool
+}
+
+func (w *mapWriter) Write(rec EnrichedRecord) error {
+	id := rec.Compound.ChEMBL
+	if id == "" || w.seen[id] {
+		return nil
+	}
+	w.seen[id] = true
+
+	key, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if w.pretty {
+		value, err = json.MarshalIndent(rec.Compound, "  ", "  ")
+	} else {
+		value, err = json.Marshal(rec.Compound)
+	}
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if !w.begun {
+		w.begun = true
+		sep = "{\n"
+	}
+	indent := ""
+	if w.pretty {
+		indent = "  "
+	}
+	_, err = fmt.Fprintf(w.w, "%s%s%s: %s", sep, indent, key, value)
+	return err
+}
+
+func (w *mapWriter) Flush() error {
+	return nil
+}
+
+func (w *mapWriter) Close() error {
+	end := "\n}\n"
+	if !w.begun {
+		end = "{}\n"
+	}
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+// unresolvedWriter writes, one per line, the ChEMBL IDs that UniChem mapped
+// to none of fields. Failed lookups are not written since they may still
+// resolve, and each ID is written once.
+type unresolvedWriter struct {
+	w      io.Writer
+	fields []string
+	seen   map[string]bool
+}
+
+func newUnresolvedWriter(out io.Writer, sources map[string]bool) *unresolvedWriter {
+	w := &unresolvedWriter{w: out, seen: map[string]bool{}}
+	for id := range sources {
+		w.fields = append(w.fields, unichem.KnownSources[id])
+	}
+	return w
+}
+
+func (w *unresolvedWriter) Write(rec EnrichedRecord) error {
+	id := rec.Compound.ChEMBL
+	if id == "" || rec.Compound.Error != "" || w.seen[id] {
+		return nil
+	}
+	fields, err := compoundFields(rec.Compound)
+	if err != nil {
+		return err
+	}
+	for _, field := range w.fields {
+		if fields[field] != "" {
+			return nil
+		}
+	}
+	w.seen[id] = true
+	_, err = fmt.Fprintln(w.w, id)
+	return err
+}
+
+func (w *unresolvedWriter) Flush() error {
+	return nil
+}
+
+// dedupWriter drops records that were already written. Compounds are
+// compared by ChEMBL ID, or in enrich mode whole records are compared.
+// Compounds without a ChEMBL ID are always written.
+type dedupWriter struct {
+	recordWriter
+	enrich bool
+	seen   map[string]bool
+}
+
+func (w *dedupWriter) Write(rec EnrichedRecord) error {
+	key := rec.Compound.ChEMBL
+	if w.enrich {
+		body, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		key = string(sum[:])
+	}
+	if key != "" {
+		if w.seen[key] {
+			return nil
+		}
+		w.seen[key] = true
+	}
+	return w.recordWriter.Write(rec)
+}
+
+// strictWriter clears the -strict entry in pending of each record it
+// writes. It wraps the writer of the output format, so a record only counts
+// once that writer has taken it.
+type strictWriter struct {
+	recordWriter
+	pending map[int64]string
+}
+
+func (w *strictWriter) Write(rec EnrichedRecord) error {
+	if err := w.recordWriter.Write(rec); err != nil {
+		return err
+	}
+	delete(w.pending, rec.seq)
+	return nil
+}
+
+// splitWriter spreads the records of a -split output over its parts, by
+// input record number, which gives parts of equal size, or with byKey by a
+// hash of the ChEMBL ID, which keeps every record of a compound in one part.
+// Either way a record goes to the same part on every run.
+type splitWriter struct {
+	parts []recordWriter
+	byKey bool
+}
+
+func (w *splitWriter) Write(rec EnrichedRecord) error {
+	n := uint64(rec.seq)
+	if w.byKey {
+		key := rec.Compound.ChEMBL
+		if key == "" {
+			key = rec.ChemblID
+		}
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		n = h.Sum64()
+	}
+	return w.parts[n%uint64(len(w.parts))].Write(rec)
+}
+
+func (w *splitWriter) Flush() error {
+	for _, part := range w.parts {
+		if err := part.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitName returns the file name of part i of the -split output name,
+// numbered before its extension: out.json.gz becomes out.000.json.gz.
+func splitName(name string, i int) string {
+	gz := ""
+	if strings.HasSuffix(name, ".gz") {
+		name, gz = strings.TrimSuffix(name, ".gz"), ".gz"
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s.%03d%s%s", strings.TrimSuffix(name, ext), i, ext, gz)
+}
+
+// createOutput creates the output file name, refusing to replace an
+// existing one unless force is set.
+func createOutput(name string, force bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(name, flags, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("output %s already exists; use -force to overwrite it", name)
+	}
+	return f, err
+}
+
+// newHTTPClient returns a client whose transport keeps enough idle
+// connections around for the worker pool to reuse them across lookups,
+// within the connection limits of cfg. Requests go through proxy when it
+// is set and otherwise follow HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func newHTTPClient(cfg config, proxy *url.URL) *http.Client {
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFunc = http.ProxyURL(proxy)
+	}
+	transport := &http.Transport{
+		Proxy:                 proxyFunc,
+		MaxIdleConns:          cfg.maxIdleConns,
+		MaxIdleConnsPerHost:   cfg.threads,
+		MaxConnsPerHost:       cfg.maxConnsPerHost,
+		IdleConnTimeout:       cfg.idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// httpClient returns the client of newHTTPClient for the -proxy of cfg.
+func (cfg config) httpClient() (*http.Client, error) {
+	var proxy *url.URL
+	if cfg.proxy != "" {
+		var err error
+		proxy, err = url.Parse(cfg.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -proxy: %w", err)
+		}
+	}
+	return newHTTPClient(cfg, proxy), nil
+}
+
+// fetcher returns a Fetcher with the client, retries, timeout and response
+// size cap of cfg, and no rate limit.
+func (cfg config) fetcher() (*unichem.Fetcher, error) {
+	client, err := cfg.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &unichem.Fetcher{
+		HTTPClient:      client,
+		Attempts:        cfg.retries + 1,
+		Backoff:         cfg.backoff,
+		Timeout:         cfg.timeout,
+		MaxResponseSize: cfg.maxResponseSize,
+		UserAgent:       userAgent(),
+	}, nil
+}
+
+// ChEMBL issues requests against the ChEMBL web services.
+type ChEMBL struct {
+	*unichem.Fetcher
+	// BaseURL is the root of the ChEMBL data API; empty means
+	// defaultChEMBLURL.
+	BaseURL string
+}
+
+// PubChem issues requests against the PubChem PUG-REST service.
+type PubChem struct {
+	*unichem.Fetcher
+	// BaseURL is the root of the PUG-REST API; empty means
+	// defaultPubChemURL.
+	BaseURL string
+}
+
+// MyGene issues requests against the MyGene.info gene annotation service.
+type MyGene struct {
+	*unichem.Fetcher
+	// BaseURL is the root of the MyGene.info API; empty means
+	// defaultMyGeneURL.
+	BaseURL string
+}
+
+// RxNorm issues requests against the NLM RxNorm REST API.
+type RxNorm struct {
+	*unichem.Fetcher
+	// BaseURL is the root of the RxNorm API; empty means defaultRxNormURL.
+	BaseURL string
+}
+
+// DGIdb issues queries against the DGIdb GraphQL API.
+type DGIdb struct {
+	*unichem.Fetcher
+	// URL is the GraphQL endpoint; empty means defaultDGIdbURL.
+	URL string
+	// Logger receives warnings about gene IDs that do not fit EntrezID;
+	// nil discards them.
+	Logger *slog.Logger
+}
+
+// Production endpoints used when no BaseURL is configured.
+const (
+	defaultChEMBLURL  = "https://www.ebi.ac.uk/chembl/api/data"
+	defaultMyGeneURL  = "https://mygene.info/v3"
+	defaultPubChemURL = "https://pubchem.ncbi.nlm.nih.gov/rest/pug"
+	defaultDGIdbURL   = "https://dgidb.org/api/graphql"
+	defaultRxNormURL  = "https://rxnav.nlm.nih.gov/REST"
+)
+
+// pubChemCIDs is the subset of a PUG-REST cids response that is used.
+type pubChemCIDs struct {
+	IdentifierList struct {
+		CID []int `json:"CID"`
+	} `json:"IdentifierList"`
+}
+
+// CIDByName looks up the PubChem compound named name. It returns an empty
+// CID when PubChem knows no such name and an error when the name is
+// ambiguous.
+func (p *PubChem) CIDByName(ctx context.Context, name string) (string, error) {
+	body, err := p.Fetch(ctx, "GET", unichem.JoinURL(p.BaseURL, defaultPubChemURL, "/compound/name/"+url.PathEscape(name)+"/cids/JSON"), nil)
+	if errors.Is(err, unichem.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("searching PubChem for %q: %w", name, err)
+	}
+
+	resp := pubChemCIDs{}
+	err = unichem.DecodeJSON(body, &resp)
+	if err != nil {
+		return "", fmt.Errorf("searching PubChem for %q: %w", name, err)
+	}
+
+	cids := resp.IdentifierList.CID
+	switch {
+	case len(cids) == 1:
+		return strconv.Itoa(cids[0]), nil
+	case len(cids) > 1:
+		ids := []string{}
+		for _, cid := range cids {
+			ids = append(ids, strconv.Itoa(cid))
+		}
+		return "", fmt.Errorf("drug name %q is ambiguous in PubChem: %s", name, strings.Join(ids, ", "))
+	}
+	return "", nil
+}
+
+// rxNormIDGroup is the subset of an RxNorm rxcui response that is used.
+// rxnormId is missing when nothing matched.
+type rxNormIDGroup struct {
+	IDGroup struct {
+		RxNormID []string `json:"rxnormId"`
+	} `json:"idGroup"`
+}
+
+// CUIsByDrugBankID looks up the RxNorm CUIs of a DrugBank ID. RxNorm does
+// not index ChEMBL IDs, so compounds without a DrugBank ID have to be
+// looked up by name.
+func (r *RxNorm) CUIsByDrugBankID(ctx context.Context, drugBankID string) ([]string, error) {
+	cuis, err := r.cuis(ctx, url.Values{"idtype": {"DRUGBANK"}, "id": {drugBankID}})
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s in RxNorm: %w", drugBankID, err)
+	}
+	return cuis, nil
+}
+
+// CUIsByName looks up the RxNorm CUIs of an exact drug name.
+func (r *RxNorm) CUIsByName(ctx context.Context, name string) ([]string, error) {
+	cuis, err := r.cuis(ctx, url.Values{"name": {name}})
+	if err != nil {
+		return nil, fmt.Errorf("searching RxNorm for %q: %w", name, err)
+	}
+	return cuis, nil
+}
+
+// cuis queries rxcui.json and returns the matching CUIs in sorted order,
+// or none when nothing matched.
+func (r *RxNorm) cuis(ctx context.Context, query url.Values) ([]string, error) {
+	body, err := r.Fetch(ctx, "GET", unichem.JoinURL(r.BaseURL, defaultRxNormURL, "/rxcui.json?")+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := rxNormIDGroup{}
+	err = unichem.DecodeJSON(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	cuis := resp.IDGroup.RxNormID
+	sort.Strings(cuis)
+	return slices.Compact(cuis), nil
+}
+
+// dgidbPageSize is the number of interactions requested per GraphQL page.
+const dgidbPageSize = 100
+
+// dgidbInteractionsQuery pages through the interactions connection,
+// optionally restricted to the named genes or drugs.
+const dgidbInteractionsQuery = `query Interactions($first: Int!, $after: String, $geneNames: [String!], $drugNames: [String!]) {
+  interactions(first: $first, after: $after, geneNames: $geneNames, drugNames: $drugNames) {
+    pageInfo { endCursor hasNextPage }
+    nodes {
+      id
+      gene { name conceptId }
+      drug { name conceptId }
+      interactionTypes { type }
+      interactionAttributes { name value }
+      publications { pmid }
+      sources { sourceDbName }
+    }
+  }
+}`
+
+// dgidbInteractions is the subset of an interactions query response that
+// is used.
+type dgidbInteractions struct {
+	Data struct {
+		Interactions struct {
+			PageInfo struct {
+				EndCursor   string `json:"endCursor"`
+				HasNextPage bool   `json:"hasNextPage"`
+			} `json:"pageInfo"`
+			Nodes []dgidbInteraction `json:"nodes"`
+		} `json:"interactions"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type dgidbInteraction struct {
+	ID   string `json:"id"`
+	Gene struct {
+		Name      string `json:"name"`
+		ConceptID string `json:"conceptId"`
+	} `json:"gene"`
+	Drug struct {
+		Name      string `json:"name"`
+		ConceptID string `json:"conceptId"`
+	} `json:"drug"`
+	InteractionTypes []struct {
+		Type string `json:"type"`
+	} `json:"interactionTypes"`
+	InteractionAttributes []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"interactionAttributes"`
+	Publications []struct {
+		PMID int64 `json:"pmid"`
+	} `json:"publications"`
+	Sources []struct {
+		SourceDBName string `json:"sourceDbName"`
+	} `json:"sources"`
+}
+
+// record converts i to the Record shape of the DGIdb REST API. The ChEMBL
+// and Entrez IDs are taken from concept IDs such as chembl:CHEMBL25 and
+// ncbigene:1021, and are left empty for drugs and genes named by other
+// namespaces, or when the Entrez ID does not fit an int64, which is logged
+// to logger unless it is nil.
+func (i dgidbInteraction) record(logger *slog.Logger) Record {
+	rec := Record{ID: i.ID, GeneName: i.Gene.Name, DrugName: i.Drug.Name}
+	if prefix, id, ok := strings.Cut(i.Drug.ConceptID, ":"); ok && strings.EqualFold(prefix, "chembl") {
+		rec.ChemblID = strings.ToUpper(id)
+	}
+	if prefix, id, ok := strings.Cut(i.Gene.ConceptID, ":"); ok && strings.EqualFold(prefix, "ncbigene") {
+		entrezID, err := strconv.ParseInt(id, 10, 64)
+		if err == nil {
+			rec.EntrezID = entrezID
+		} else if logger != nil {
+			logger.Warn("invalid Entrez ID from DGIdb", "interaction", i.ID, "err", err)
+		}
+	}
+	for _, t := range i.InteractionTypes {
+		rec.InteractionTypes = append(rec.InteractionTypes, t.Type)
+	}
+	for _, a := range i.InteractionAttributes {
+		rec.Attributes = append(rec.Attributes, Attribute{Name: a.Name, Value: a.Value})
+	}
+	for _, p := range i.Publications {
+		rec.Publications = append(rec.Publications, p.PMID)
+	}
+	for _, src := range i.Sources {
+		rec.Sources = append(rec.Sources, src.SourceDBName)
+	}
+	return rec
+}
+
+// Interactions pages through the DGIdb interactions, restricted to genes
+// and drugs when they are not empty, and passes each one to fn as a
+// Record, stopping at the first error fn returns.
+func (d *DGIdb) Interactions(ctx context.Context, genes, drugs []string, fn func(Record) error) error {
+	endpoint := unichem.JoinURL(d.URL, defaultDGIdbURL, "")
+	variables := map[string]interface{}{"first": dgidbPageSize}
+	if len(genes) > 0 {
+		variables["geneNames"] = genes
+	}
+	if len(drugs) > 0 {
+		variables["drugNames"] = drugs
+	}
+	for page := 1; ; page++ {
+		payload, err := json.Marshal(map[string]interface{}{
+			"query":     dgidbInteractionsQuery,
+			"variables": variables,
+		})
+		if err != nil {
+			return err
+		}
+		body, err := d.Fetch(ctx, "POST", endpoint, payload)
+		if err != nil {
+			return fmt.Errorf("fetching DGIdb interactions page %d: %w", page, err)
+		}
+
+		resp := dgidbInteractions{}
+		err = unichem.DecodeJSON(body, &resp)
+		if err != nil {
+			return fmt.Errorf("fetching DGIdb interactions page %d: %w", page, err)
+		}
+		if len(resp.Errors) > 0 {
+			messages := []string{}
+			for _, e := range resp.Errors {
+				messages = append(messages, e.Message)
+			}
+			return fmt.Errorf("fetching DGIdb interactions page %d: %s", page, strings.Join(messages, "; "))
+		}
+
+		interactions := resp.Data.Interactions
+		for _, i := range interactions.Nodes {
+			if err := fn(i.record(d.Logger)); err != nil {
+				return err
+			}
+		}
+		if !interactions.PageInfo.HasNextPage || interactions.PageInfo.EndCursor == "" {
+			return nil
+		}
+		variables["after"] = interactions.PageInfo.EndCursor
+	}
+}
+
+// myGeneHit is the subset of a MyGene.info gene record that is used.
+// ensembl is an object, or a list of them for genes on several assemblies.
+type myGeneHit struct {
+	Symbol  string          `json:"symbol"`
+	HGNC    string          `json:"HGNC"`
+	Ensembl json.RawMessage `json:"ensembl"`
+}
+
+type myGeneEnsembl struct {
+	Gene string `json:"gene"`
+}
+
+// GetGeneIDs resolves an Entrez gene ID to its Ensembl gene ID, HGNC ID
+// and HGNC symbol.
+func (g *MyGene) GetGeneIDs(ctx context.Context, entrezID int64) (GeneID, error) {
+	u := unichem.JoinURL(g.BaseURL, defaultMyGeneURL, fmt.Sprintf("/gene/%d?", entrezID)) + url.Values{"fields": {"symbol,HGNC,ensembl.gene"}}.Encode()
+	body, err := g.Fetch(ctx, "GET", u, nil)
+	if err != nil {
+		return GeneID{}, fmt.Errorf("resolving Entrez gene %d: %w", entrezID, err)
+	}
+
+	hit := myGeneHit{}
+	err = unichem.DecodeJSON(body, &hit)
+	if err != nil {
+		return GeneID{}, fmt.Errorf("resolving Entrez gene %d: %w", entrezID, err)
+	}
+
+	gene := GeneID{Symbol: hit.Symbol}
+	if hit.HGNC != "" {
+		gene.HGNC = "HGNC:" + hit.HGNC
+	}
+	one := myGeneEnsembl{}
+	many := []myGeneEnsembl{}
+	if json.Unmarshal(hit.Ensembl, &one) == nil {
+		gene.Ensembl = one.Gene
+	} else if json.Unmarshal(hit.Ensembl, &many) == nil && len(many) > 0 {
+		gene.Ensembl = many[0].Gene
+	}
+	return gene, nil
+}
+
+// chemblMolecules is the subset of a ChEMBL molecule search response that
+// is used.
+type chemblMolecules struct {
+	Molecules []struct {
+		MoleculeChEMBLID string `json:"molecule_chembl_id"`
+	} `json:"molecules"`
+}
+
+// chemblMolecule is the subset of a ChEMBL molecule record that is used.
+// molecule_properties is null for molecules such as biologics that have no
+// computed properties, and full_mwt is sent as a string.
+type chemblMolecule struct {
+	ATCClassifications []string `json:"atc_classifications"`
+	Properties         *struct {
+		FullMWT          json.Number `json:"full_mwt"`
+		MolecularFormula string      `json:"full_molformula"`
+	} `json:"molecule_properties"`
+}
+
+// Molecule fetches the ChEMBL molecule record of chemblID.
+func (c *ChEMBL) Molecule(ctx context.Context, chemblID string) (chemblMolecule, error) {
+	body, err := c.Fetch(ctx, "GET", unichem.JoinURL(c.BaseURL, defaultChEMBLURL, "/molecule/"+url.PathEscape(chemblID)+".json"), nil)
+	if err != nil {
+		return chemblMolecule{}, fmt.Errorf("fetching ChEMBL molecule %s: %w", chemblID, err)
+	}
+
+	mol := chemblMolecule{}
+	err = unichem.DecodeJSON(body, &mol)
+	if err != nil {
+		return chemblMolecule{}, fmt.Errorf("fetching ChEMBL molecule %s: %w", chemblID, err)
+	}
+	return mol, nil
+}
+
+// ChEMBLIDByName searches ChEMBL for a molecule whose preferred name, or
+// failing that a synonym, matches name. It returns an empty ID when nothing
+// matches and an error when the name is ambiguous.
+func (c *ChEMBL) ChEMBLIDByName(ctx context.Context, name string) (string, error) {
+	base := unichem.JoinURL(c.BaseURL, defaultChEMBLURL, "/molecule.json?")
+	for _, field := range []string{"pref_name__iexact", "molecule_synonyms__molecule_synonym__iexact"} {
+		body, err := c.Fetch(ctx, "GET", base+url.Values{field: {name}}.Encode(), nil)
+		if err != nil {
+			return "", fmt.Errorf("searching ChEMBL for %q: %w", name, err)
+		}
+
+		resp := chemblMolecules{}
+		err = unichem.DecodeJSON(body, &resp)
+		if err != nil {
+			return "", fmt.Errorf("searching ChEMBL for %q: %w", name, err)
+		}
+
+		ids := []string{}
+		seen := map[string]bool{}
+		for _, m := range resp.Molecules {
+			if !seen[m.MoleculeChEMBLID] {
+				seen[m.MoleculeChEMBLID] = true
+				ids = append(ids, m.MoleculeChEMBLID)
+			}
+		}
+		switch {
+		case len(ids) == 1:
+			return ids[0], nil
+		case len(ids) > 1:
+			sort.Strings(ids)
+			return "", fmt.Errorf("drug name %q is ambiguous in ChEMBL: %s", name, strings.Join(ids, ", "))
+		}
+	}
+	return "", nil
+}
+
+// lookupCache memoizes lookups by key. Concurrent lookups of the same key
+// wait for the first one instead of issuing duplicate requests.
+type lookupCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]*cacheEntry[V]
+}
+
+type cacheEntry[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+func newLookupCache[K comparable, V any]() *lookupCache[K, V] {
+	return &lookupCache[K, V]{entries: map[K]*cacheEntry[V]{}}
+}
+
+// get returns the cached result for key, calling fetch to populate it on
+// first use. cached reports whether fetch was called by an earlier lookup.
+func (c *lookupCache[K, V]) get(key K, fetch func() (V, error)) (value V, cached bool, err error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry[V]{done: make(chan struct{})}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-entry.done
+		return entry.value, true, entry.err
+	}
+
+	entry.value, entry.err = fetch()
+	close(entry.done)
+	return entry.value, false, entry.err
+}
+
+// counters tracks run statistics. Fields are updated atomically so all
+// workers can share one instance.
+type counters struct {
+	records   int64
+	cacheHits int64
+	errors    int64
+	// unresolved counts records with no ID to look up.
+	unresolved int64
+	// badLines counts skipped input lines that did not parse.
+	badLines int64
+	// filtered counts records dropped by -filter-source,
+	// -filter-interaction-type and -only-ids.
+	filtered int64
+	// resolved counts records with a mapping, keyed by the CompoundID JSON
+	// field of each selected source. The map itself is never modified after
+	// newCounters.
+	resolved map[string]*int64
+	// latency holds the request latencies recorded with -trace; nil when
+	// tracing is off.
+	latency *latencies
+}
+
+func newCounters(sources map[string]bool) *counters {
+	c := &counters{resolved: map[string]*int64{}}
+	for id := range sources {
+		c.resolved[unichem.KnownSources[id]] = new(int64)
+	}
+	return c
+}
+
+// countResolved records which sources compound was mapped to.
+func (c *counters) countResolved(compound unichem.CompoundID) {
+	fields, err := compoundFields(compound)
+	if err != nil {
+		return
+	}
+	for name, n := range c.resolved {
+		if fields[name] != "" {
+			atomic.AddInt64(n, 1)
+		}
+	}
+}
+
+// summary is the end of run report.
+type summary struct {
+	Records    int64            `json:"records"`
+	CacheHits  int64            `json:"cache_hits"`
+	Failed     int64            `json:"failed"`
+	Unresolved int64            `json:"unresolved"`
+	BadLines   int64            `json:"bad_lines"`
+	Filtered   int64            `json:"filtered"`
+	Resolved   map[string]int64 `json:"resolved"`
+	Seconds    float64          `json:"seconds"`
+	// Latency is only set with -trace.
+	Latency *latencySummary `json:"latency,omitempty"`
+}
+
+// latencySummary describes the distribution of request latencies, in
+// milliseconds.
+type latencySummary struct {
+	Requests int     `json:"requests"`
+	P50      float64 `json:"p50_ms"`
+	P95      float64 `json:"p95_ms"`
+	P99      float64 `json:"p99_ms"`
+	Max      float64 `json:"max_ms"`
+}
+
+// latencies collects request latencies from concurrent workers.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+// summary returns the nearest-rank percentiles of the samples so far.
+func (l *latencies) summary() *latencySummary {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	s := &latencySummary{Requests: len(sorted)}
+	if len(sorted) == 0 {
+		return s
+	}
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	s.P50 = ms(rank(0.50))
+	s.P95 = ms(rank(0.95))
+	s.P99 = ms(rank(0.99))
+	s.Max = ms(sorted[len(sorted)-1])
+	return s
+}
+
+func (c *counters) summary(start time.Time) summary {
+	s := summary{
+		Records:    atomic.LoadInt64(&c.records),
+		CacheHits:  atomic.LoadInt64(&c.cacheHits),
+		Failed:     atomic.LoadInt64(&c.errors),
+		Unresolved: atomic.LoadInt64(&c.unresolved),
+		BadLines:   atomic.LoadInt64(&c.badLines),
+		Filtered:   atomic.LoadInt64(&c.filtered),
+		Resolved:   map[string]int64{},
+		Seconds:    time.Since(start).Seconds(),
+	}
+	for name, n := range c.resolved {
+		s.Resolved[name] = atomic.LoadInt64(n)
+	}
+	if c.latency != nil {
+		s.Latency = c.latency.summary()
+	}
+	return s
+}
+
+func (s summary) String() string {
+	names := []string{}
+	for name := range s.Resolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resolved := []string{}
+	for _, name := range names {
+		resolved = append(resolved, fmt.Sprintf("%s=%d", name, s.Resolved[name]))
+	}
+	line := fmt.Sprintf("processed %d records in %s: %d failed, %d without an ID, %d bad lines skipped, %d filtered out, %d cache hits; resolved %s",
+		s.Records, time.Duration(s.Seconds*float64(time.Second)).Round(time.Millisecond),
+		s.Failed, s.Unresolved, s.BadLines, s.Filtered, s.CacheHits, strings.Join(resolved, " "))
+	if s.Latency != nil {
+		line += fmt.Sprintf("; %d requests took p50=%.1fms p95=%.1fms p99=%.1fms max=%.1fms",
+			s.Latency.Requests, s.Latency.P50, s.Latency.P95, s.Latency.P99, s.Latency.Max)
+	}
+	return line
+}
+
+// writeMetrics writes the counters, and the request counts of each
+// service's Fetcher in fetchers, in the Prometheus text exposition format.
+func (c *counters) writeMetrics(w io.Writer, fetchers map[string]*unichem.Fetcher) {
+	metric := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	metric("dgidb_transform_records_total", "Records processed.", atomic.LoadInt64(&c.records))
+	metric("dgidb_transform_cache_hits_total", "Lookups answered from a cache.", atomic.LoadInt64(&c.cacheHits))
+	metric("dgidb_transform_errors_total", "Lookups that failed.", atomic.LoadInt64(&c.errors))
+	metric("dgidb_transform_unresolved_total", "Records without an ID to look up.", atomic.LoadInt64(&c.unresolved))
+
+	services := []string{}
+	for service := range fetchers {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	fmt.Fprintf(w, "# HELP dgidb_transform_requests_total HTTP requests sent to each service, retries included.\n# TYPE dgidb_transform_requests_total counter\n")
+	for _, service := range services {
+		fmt.Fprintf(w, "dgidb_transform_requests_total{service=%q} %d\n", service, fetchers[service].Requests())
+	}
+	fmt.Fprintf(w, "# HELP dgidb_transform_retries_total HTTP requests that retried a failed one, by service.\n# TYPE dgidb_transform_retries_total counter\n")
+	for _, service := range services {
+		fmt.Fprintf(w, "dgidb_transform_retries_total{service=%q} %d\n", service, fetchers[service].Retries())
+	}
+
+	names := []string{}
+	for name := range c.resolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# HELP dgidb_transform_resolved_total Records mapped to each source.\n# TYPE dgidb_transform_resolved_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "dgidb_transform_resolved_total{source=%q} %d\n", name, atomic.LoadInt64(c.resolved[name]))
+	}
+}
+
+// report formats a one line progress summary.
+func (c *counters) report(start time.Time) string {
+	records := atomic.LoadInt64(&c.records)
+	elapsed := time.Since(start)
+	return fmt.Sprintf("%d records (%.1f/s), %d cache hits, %d errors, %d without an ID, %s elapsed",
+		records, float64(records)/elapsed.Seconds(),
+		atomic.LoadInt64(&c.cacheHits), atomic.LoadInt64(&c.errors),
+		atomic.LoadInt64(&c.unresolved), elapsed.Round(time.Second))
+}
+
+// diskCache persists resolved compounds as one JSON file per ChEMBL ID so
+// reruns do not repeat UniChem lookups.
+type diskCache struct {
+	dir string
+	// ttl is how long an entry stays valid; zero means forever.
+	ttl time.Duration
+	// options describes the options the compounds are resolved with; an
+	// entry written under other options is not served.
+	options string
+}
+
+// diskEntry is the file a diskCache keeps a compound in.
+type diskEntry struct {
+	Options  string             `json:"options"`
+	Compound unichem.CompoundID `json:"compound"`
+}
+
+func (d *diskCache) path(key string) string {
+	return filepath.Join(d.dir, url.PathEscape(key)+".json")
+}
+
+// load returns the cached compound for key, if a fresh entry exists.
+func (d *diskCache) load(key string) (unichem.CompoundID, bool) {
+	entry := diskEntry{}
+	p := d.path(key)
+	info, err := os.Stat(p)
+	if err != nil {
+		return entry.Compound, false
+	}
+	if d.ttl > 0 && time.Since(info.ModTime()) > d.ttl {
+		return entry.Compound, false
+	}
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return entry.Compound, false
+	}
+	if json.Unmarshal(body, &entry) != nil || entry.Options != d.options {
+		return unichem.CompoundID{}, false
+	}
+	return entry.Compound, true
+}
+
+// store writes compound to the cache under key, replacing any existing
+// entry.
+func (d *diskCache) store(key string, compound unichem.CompoundID) error {
+	body, err := json.Marshal(diskEntry{Options: d.options, Compound: compound})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(d.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+// gzipReader decompresses r when it starts with the gzip magic number and
+// returns it unchanged otherwise.
+func gzipReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// streamReader remembers the first error other than io.EOF returned by the
+// reader it wraps, which tells a stream that ended early, such as a
+// truncated or corrupt gzip file, apart from one read to the end.
+type streamReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF && s.err == nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// lineScanner scans the lines of r, with lines up to maxLine bytes. When r
+// fails partway through a line that line is dropped rather than returned
+// cut short, so a truncated stream never yields a partial ID or record.
+func lineScanner(r io.Reader, maxLine int) *bufio.Scanner {
+	sr := &streamReader{r: r}
+	scanner := bufio.NewScanner(sr)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		return bufio.ScanLines(data, atEOF && sr.err == nil)
+	})
+	return scanner
+}
+
+// gzipWriteCloser compresses into an underlying WriteCloser; Close flushes
+// the gzip stream before closing it.
+type gzipWriteCloser struct {
+	*gzip.Writer
+	out io.WriteCloser
+}
+
+func (g *gzipWriteCloser) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readRecords decodes interaction Records from r and passes each one to fn,
+// stopping at the first error fn returns. The input may be newline delimited
+// JSON or a single top-level JSON array, which is decoded incrementally
+// rather than loaded into memory. maxLine caps the size of a single newline
+// delimited record, and blank lines between records are ignored. A newline
+// delimited record that fails to parse is passed to badLine, which may
+// return nil to skip it; when badLine is nil the parse error ends the read.
+func readRecords(r io.Reader, maxLine int, badLine func(line int, err error) error, fn func(Record) error) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			br.UnreadByte()
+			break
+		}
+	}
+
+	if first, _ := br.Peek(1); first[0] == '[' {
+		dec := json.NewDecoder(br)
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			interaction := Record{}
+			if err := dec.Decode(&interaction); err != nil {
+				if err == io.EOF {
+					// The array was cut short between elements.
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			if err := fn(interaction); err != nil {
+				return err
+			}
+		}
+		_, err := dec.Token()
+		return err
+	}
+
+	scanner := lineScanner(br, maxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		interaction := Record{}
+		err := json.Unmarshal(scanner.Bytes(), &interaction)
+		if err != nil {
+			if badLine == nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := badLine(line, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(interaction); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return fmt.Errorf("record longer than %d bytes: %w", maxLine, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.WriteCloser
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// checkpoint records how far a run got. Records are numbered in input
+// order; every record before Next, and each record in Done, has been
+// written to the first OutputBytes bytes of the output.
+type checkpoint struct {
+	Next        int64   `json:"next"`
+	Done        []int64 `json:"done,omitempty"`
+	OutputBytes int64   `json:"output_bytes"`
+}
+
+func loadCheckpoint(path string) (checkpoint, error) {
+	cp := checkpoint{}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(body, &cp)
+	return cp, err
+}
+
+func (cp checkpoint) save(path string) error {
+	body, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, body, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// completion tracks which input records have been written. Workers finish
+// records out of order, so besides the contiguous prefix it remembers the
+// stragglers beyond it.
+type completion struct {
+	next int64
+	done map[int64]bool
+}
+
+func newCompletion(cp checkpoint) *completion {
+	c := &completion{next: cp.Next, done: map[int64]bool{}}
+	for _, seq := range cp.Done {
+		c.done[seq] = true
+	}
+	return c
+}
+
+func (c *completion) finish(seq int64) {
+	c.done[seq] = true
+	for c.done[c.next] {
+		delete(c.done, c.next)
+		c.next++
+	}
+}
+
+func (c *completion) finished(seq int64) bool {
+	return seq < c.next || c.done[seq]
+}
+
+func (c *completion) checkpoint(outputBytes int64) checkpoint {
+	cp := checkpoint{Next: c.next, OutputBytes: outputBytes}
+	for seq := range c.done {
+		cp.Done = append(cp.Done, seq)
+	}
+	sort.Slice(cp.Done, func(i, j int) bool { return cp.Done[i] < cp.Done[j] })
+	return cp
+}
+
+// readIDs passes each non-empty line of r to fn as a compound ID, stopping
+// at the first error fn returns.
+func readIDs(r io.Reader, maxLine int, fn func(string) error) error {
+	scanner := lineScanner(r, maxLine)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" {
+			continue
+		}
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseFilter splits a comma separated filter value into a set of
+// lower-cased values. An empty list yields an empty set, which matches
+// everything.
+func parseFilter(list string) map[string]bool {
+	filter := map[string]bool{}
+	for _, v := range strings.Split(list, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v != "" {
+			filter[v] = true
+		}
+	}
+	return filter
+}
+
+// parseNames splits a comma separated list of names, dropping empty ones.
+// Unlike parseFilter it keeps their case and order.
+func parseNames(list string) []string {
+	names := []string{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// limitedServices are the services -source-rate and -source-concurrency
+// accept.
+var limitedServices = []string{"unichem", "chembl", "pubchem", "mygene", "dgidb", "rxnorm"}
+
+// parseServiceLimits parses a comma separated list of service=value pairs
+// given to the flag name, checking each service is known and each value is
+// a non-negative number.
+func parseServiceLimits[T int | float64](name, list string, parse func(string) (T, error)) (map[string]T, error) {
+	limits := map[string]T{}
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		service, value, ok := strings.Cut(pair, "=")
+		service = strings.ToLower(strings.TrimSpace(service))
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q; expected service=value", name, pair)
+		}
+		if !slices.Contains(limitedServices, service) {
+			return nil, fmt.Errorf("unknown %s service %q; expected one of %s", name, service, strings.Join(limitedServices, ", "))
+		}
+		limit, err := parse(strings.TrimSpace(value))
+		if err != nil || !(limit >= 0) {
+			return nil, fmt.Errorf("invalid %s value %q for %s; expected a non-negative number", name, value, service)
+		}
+		limits[service] = limit
+	}
+	return limits, nil
+}
+
+// rateTicker returns a ticker pacing requests to rate per second, or nil for
+// no limit when rate is not positive. A rate above one request per
+// nanosecond, the finest interval a ticker has, is no limit either.
+func rateTicker(rate float64) *time.Ticker {
+	if !(rate > 0) {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval <= 0 {
+		return nil
+	}
+	return time.NewTicker(interval)
+}
+
+// readAllowlist reads the ChEMBL IDs in the file name, one per line, into a
+// set of normalized IDs.
+func readAllowlist(cfg config, name string) (map[string]bool, error) {
+	input, file, err := openInput(cfg, name)
+	if err != nil {
+		return nil, fmt.Errorf("reading -only-ids: %w", err)
+	}
+	defer file.Close()
+
+	allow := map[string]bool{}
+	err = readIDs(input, cfg.maxLine, func(id string) error {
+		normalized, err := unichem.NormalizeChEMBLID(id)
+		if err != nil {
+			return err
+		}
+		allow[normalized] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading -only-ids: %w", err)
+	}
+	return allow, nil
+}
+
+// allowlisted reports whether the ChEMBL ID id is in allow, which allows
+// everything when it is nil.
+func allowlisted(allow map[string]bool, id string) bool {
+	if allow == nil {
+		return true
+	}
+	normalized, err := unichem.NormalizeChEMBLID(id)
+	return err == nil && allow[normalized]
+}
+
+// matchesFilter reports whether any of values is in filter, ignoring case.
+func matchesFilter(filter map[string]bool, values []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if filter[strings.ToLower(v)] {
+			return true
+		}
+	}
+	return false
+}
+
+// recordID returns the value of field in interaction, which is either one
+// of its ID or name fields, by JSON name, or attributes.<name> for the value
+// of its first attribute called name, compared case-insensitively like
+// recordInChIKey does.
+func recordID(interaction Record, field string) string {
+	if name, ok := strings.CutPrefix(field, "attributes."); ok {
+		for _, a := range interaction.Attributes {
+			if strings.EqualFold(a.Name, name) {
+				return a.Value
+			}
+		}
+		return ""
+	}
+	switch field {
+	case "drug_name":
+		return interaction.DrugName
+	case "id":
+		return interaction.ID
+	}
+	return interaction.ChemblID
+}
+
+// validIDField reports whether recordID understands field.
+func validIDField(field string) bool {
+	switch field {
+	case "chembl_id", "drug_name", "id":
+		return true
+	}
+	name, ok := strings.CutPrefix(field, "attributes.")
+	return ok && name != ""
+}
+
+// recordInChIKey returns the value of an InChIKey attribute on interaction,
+// if it has one.
+func recordInChIKey(interaction Record) string {
+	for _, a := range interaction.Attributes {
+		if strings.EqualFold(a.Name, "inchikey") {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// mergeAttributes merges the attributes sharing a name, in order of first
+// appearance, unioning their sources and collecting conflicting values.
+func mergeAttributes(attributes []Attribute) []Attribute {
+	if len(attributes) < 2 {
+		return attributes
+	}
+	merged := []Attribute{}
+	index := map[string]int{}
+	for _, a := range attributes {
+		i, ok := index[a.Name]
+		if !ok {
+			index[a.Name] = len(merged)
+			merged = append(merged, Attribute{Name: a.Name, Value: a.Value, Sources: appendNew(nil, a.Sources...)})
+			continue
+		}
+		m := &merged[i]
+		if a.Value != m.Value && !slices.Contains(m.Values, a.Value) {
+			if len(m.Values) == 0 {
+				m.Values = []string{m.Value}
+			}
+			m.Values = append(m.Values, a.Value)
+		}
+		m.Sources = appendNew(m.Sources, a.Sources...)
+	}
+	return merged
+}
+
+// normalizeAttributes merges the duplicate attributes of interaction and of
+// each of its claims.
+func normalizeAttributes(interaction *Record) {
+	interaction.Attributes = mergeAttributes(interaction.Attributes)
+	for i := range interaction.InteractionClaims {
+		claim := &interaction.InteractionClaims[i]
+		claim.Attributes = mergeAttributes(claim.Attributes)
+	}
+}
+
+// appendNew appends the values not already in list.
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// manifest records what produced an output file.
+type manifest struct {
+	Tool         string            `json:"tool"`
+	Version      string            `json:"version"`
+	Created      time.Time         `json:"created"`
+	Input        string            `json:"input"`
+	InputSource  string            `json:"input_source"`
+	UniChemURL   string            `json:"unichem_url"`
+	API          string            `json:"api"`
+	Mode         string            `json:"mode"`
+	OutputFormat string            `json:"output_format"`
+	Sources      map[string]string `json:"sources"`
+}
+
+func newManifest(cfg config) manifest {
+	m := manifest{
+		Tool:         "dgidb-transform",
+		Version:      versionString(),
+		Created:      time.Now().UTC(),
+		Input:        cfg.inputFile,
+		InputSource:  cfg.inputSource,
+		UniChemURL:   cfg.unichemURL,
+		API:          cfg.api,
+		Mode:         cfg.mode,
+		OutputFormat: cfg.outputFormat,
+		Sources:      map[string]string{},
+	}
+	if cfg.fromDGIdb {
+		m.Input = cfg.dgidbURL
+	} else if m.Input == "" {
+		m.Input = "-"
+	}
+	for id := range cfg.sources {
+		m.Sources[id] = unichem.KnownSources[id]
+	}
+	return m
+}
+
+// errLimit stops reading the input once -limit records have been queued.
+var errLimit = errors.New("record limit reached")
+
+// errInterrupted stops reading the input when a shutdown signal arrives.
+var errInterrupted = errors.New("interrupted")
+
+// shutdownGrace is how long lookups in flight may run after a shutdown
+// signal before they are cancelled.
+const shutdownGrace = 10 * time.Second
+
+// orderedWindow bounds how many records -ordered lets run ahead of the
+// oldest one still being looked up.
+const orderedWindow = 1024
+
+// job is a single lookup handed to the worker pool. interaction is empty
+// when the input is a plain list of IDs.
+type job struct {
+	seq         int64
+	interaction Record
+	id          string
+	gene        *GeneID
+}
+
+// version is the release of this tool, normally set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
+// versionString describes this build, adding the VCS revision recorded by
+// the go tool when it is available.
+func versionString() string {
+	v := version
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" {
+		v += " (" + revision
+		if modified {
+			v += "-dirty"
+		}
+		v += ")"
+	}
+	return v
+}
+
+// userAgent identifies this tool to the EBI services, as they ask clients
+// to do.
+func userAgent() string {
+	return "dgidb-transform/" + version + " (+https://github.com/biostream/dgidb-transform)"
+}
+
+// config holds the command line options.
+type config struct {
+	inputFile           string
+	outputFile          string
+	threads             int
+	maxIdleConns        int
+	maxConnsPerHost     int
+	idleTimeout         time.Duration
+	retries             int
+	emptyRetries        int
+	emptyThreshold      int
+	backoff             time.Duration
+	rate                float64
+	sourceRate          map[string]float64
+	sourceConcurrency   map[string]int
+	failFast            bool
+	cacheDir            string
+	cacheTTL            time.Duration
+	cacheRefresh        bool
+	timeout             time.Duration
+	maxLine             int
+	maxResponseSize     int64
+	mode                string
+	outputFormat        string
+	forceGzip           bool
+	progress            time.Duration
+	api                 string
+	inputSource         string
+	idField             string
+	inputFormat         string
+	resolveByName       bool
+	skipUnresolved      bool
+	skipBadLines        bool
+	allSources          bool
+	dryRun              bool
+	unichemURL          string
+	fromDGIdb           bool
+	dgidbURL            string
+	dgidbGenes          []string
+	dgidbDrugs          []string
+	proxy               string
+	enrichGenes         bool
+	dedup               bool
+	withStructure       bool
+	withATC             bool
+	withRxNorm          bool
+	rxnormURL           string
+	withSourceMeta      bool
+	keepRaw             bool
+	connectivity        bool
+	pubchemFallback     bool
+	pubchemURL          string
+	includeObsolete     bool
+	withProperties      bool
+	limit               int64
+	metricsAddr         string
+	flat                bool
+	manifest            string
+	keepFailed          bool
+	reportFile          string
+	checkpointFile      string
+	checkpointInterval  time.Duration
+	resume              bool
+	logLevel            string
+	logFormat           string
+	quiet               bool
+	trace               bool
+	noCreateDirs        bool
+	force               bool
+	appendOutput        bool
+	split               int
+	splitBy             string
+	strict              bool
+	pretty              bool
+	emitUnresolved      bool
+	ordered             bool
+	keepProvenance      bool
+	normalizeAttributes bool
+
+	// sources is parsed from the -sources list.
+	sources map[string]bool
+	// filterSource and filterType are parsed from -filter-source and
+	// -filter-interaction-type.
+	filterSource map[string]bool
+	filterType   map[string]bool
+	// onlyIDs is read from the -only-ids file; nil allows every ID.
+	onlyIDs map[string]bool
+}
+
+// defaultConfig returns the options main starts from before reading the
+// environment and flags.
+func defaultConfig() config {
+	return config{
+		threads:            1,
+		maxIdleConns:       100,
+		idleTimeout:        90 * time.Second,
+		retries:            3,
+		emptyThreshold:     1,
+		backoff:            time.Second,
+		rate:               3.0,
+		timeout:            30 * time.Second,
+		maxLine:            16 * 1024 * 1024,
+		maxResponseSize:    unichem.DefaultMaxResponseSize,
+		mode:               "ids",
+		outputFormat:       "json",
+		api:                "v1",
+		inputSource:        "1",
+		idField:            "chembl_id",
+		checkpointInterval: 30 * time.Second,
+		logLevel:           "info",
+		logFormat:          "text",
+		unichemURL:         unichem.DefaultURL,
+		dgidbURL:           defaultDGIdbURL,
+		splitBy:            "record",
+	}
+}
+
+func main() {
+	cfg := defaultConfig()
+	if env := os.Getenv("UNICHEM_URL"); env != "" {
+		cfg.unichemURL = env
+	}
+	sourceList := ""
+	filterSource := ""
+	filterType := ""
+	onlyIDs := ""
+	sourceRate := ""
+	sourceConcurrency := ""
+	dgidbGenes := ""
+	dgidbDrugs := ""
+	showVersion := false
+	flag.BoolVar(&showVersion, "version", showVersion, "print the version and exit")
+	flag.StringVar(&cfg.inputFile, "input", cfg.inputFile, "interactions input file or http(s) URL; reads stdin when empty")
+	flag.BoolVar(&cfg.fromDGIdb, "from-dgidb", cfg.fromDGIdb, "fetch the interactions from the DGIdb GraphQL API instead of reading -input")
+	flag.StringVar(&cfg.dgidbURL, "dgidb-url", cfg.dgidbURL, "DGIdb GraphQL endpoint used by -from-dgidb")
+	flag.StringVar(&dgidbGenes, "dgidb-genes", dgidbGenes, "comma separated gene names to fetch the interactions of with -from-dgidb")
+	flag.StringVar(&dgidbDrugs, "dgidb-drugs", dgidbDrugs, "comma separated drug names to fetch the interactions of with -from-dgidb")
+	flag.StringVar(&cfg.outputFile, "output", cfg.outputFile, "output file path")
+	flag.BoolVar(&cfg.noCreateDirs, "no-create-dirs", cfg.noCreateDirs, "fail when the -output directory does not exist instead of creating it")
+	flag.BoolVar(&cfg.ordered, "ordered", cfg.ordered, "write results in input order even with several -threads")
+	flag.BoolVar(&cfg.emitUnresolved, "emit-unresolved", cfg.emitUnresolved, "write only the ChEMBL IDs mapped to none of the selected sources, one per line")
+	flag.BoolVar(&cfg.pretty, "pretty", cfg.pretty, "indent -output-format map output for reading")
+	flag.BoolVar(&cfg.force, "force", cfg.force, "overwrite an existing -output file")
+	flag.BoolVar(&cfg.appendOutput, "append", cfg.appendOutput, "append to an existing -output file instead of replacing it")
+	flag.IntVar(&cfg.split, "split", cfg.split, "write the output as this many files, numbered before the extension of -output (out.000.json, out.001.json, ...)")
+	flag.StringVar(&cfg.splitBy, "split-by", cfg.splitBy, "how -split assigns records to files: record for equal counts by input record number, or key to keep each ChEMBL ID in one file")
+	flag.StringVar(&sourceList, "sources", sourceList, "comma separated UniChem src_ids to resolve (default all known sources)")
+	flag.IntVar(&cfg.threads, "threads", cfg.threads, "number of concurrent UniChem lookups")
+	flag.IntVar(&cfg.maxIdleConns, "max-idle-conns", cfg.maxIdleConns, "maximum idle HTTP connections kept across all hosts; 0 means no limit")
+	flag.IntVar(&cfg.maxConnsPerHost, "max-conns-per-host", cfg.maxConnsPerHost, "maximum HTTP connections per host, idle or in use; 0 means no limit")
+	flag.DurationVar(&cfg.idleTimeout, "idle-timeout", cfg.idleTimeout, "how long an idle HTTP connection is kept; 0 means forever")
+	flag.IntVar(&cfg.retries, "retries", cfg.retries, "number of times to retry a failed UniChem request")
+	flag.IntVar(&cfg.emptyRetries, "empty-retries", cfg.emptyRetries, "number of times to repeat a lookup whose response has fewer than -empty-threshold mappings, in case it is transient")
+	flag.IntVar(&cfg.emptyThreshold, "empty-threshold", cfg.emptyThreshold, "number of mappings below which -empty-retries repeats a lookup")
+	flag.DurationVar(&cfg.backoff, "backoff", cfg.backoff, "delay before the first retry; doubles on each retry")
+	flag.Float64Var(&cfg.rate, "rate", cfg.rate, "maximum UniChem requests per second across all threads; 0 disables the limit")
+	flag.StringVar(&sourceRate, "source-rate", sourceRate, "comma separated service=rate limits on requests per second, e.g. unichem=5,pubchem=3; services are unichem, chembl, pubchem, mygene, dgidb and rxnorm, and unichem overrides -rate")
+	flag.StringVar(&sourceConcurrency, "source-concurrency", sourceConcurrency, "comma separated service=n limits on the requests in flight to each service, e.g. chembl=2; 0 means no limit")
+	flag.BoolVar(&cfg.failFast, "fail-fast", cfg.failFast, "abort on the first failed UniChem lookup instead of recording the error")
+	flag.StringVar(&cfg.cacheDir, "cache-dir", cfg.cacheDir, "directory used to persist resolved compounds between runs; entries are only reused by runs with the same -api, -sources and lookup options")
+	flag.DurationVar(&cfg.cacheTTL, "cache-ttl", cfg.cacheTTL, "maximum age of a -cache-dir entry; 0 keeps entries forever")
+	flag.BoolVar(&cfg.cacheRefresh, "cache-refresh", cfg.cacheRefresh, "ignore existing -cache-dir entries and re-fetch them")
+	flag.DurationVar(&cfg.timeout, "timeout", cfg.timeout, "deadline for each HTTP request, and for an -input URL to start responding; 0 disables it")
+	flag.IntVar(&cfg.maxLine, "max-line-size", cfg.maxLine, "maximum size in bytes of a single input record")
+	flag.Int64Var(&cfg.maxResponseSize, "max-response-size", cfg.maxResponseSize, "maximum size in bytes of an HTTP response body; larger responses fail the request")
+	flag.StringVar(&cfg.mode, "mode", cfg.mode, "output mode: ids emits unichem.CompoundID objects, enrich emits records with a nested compound")
+	flag.StringVar(&cfg.outputFormat, "output-format", cfg.outputFormat, "output format: json, jsonpb (proto3 JSON of dgidb.proto), tsv, csv or map (one JSON object keyed by ChEMBL ID)")
+	flag.BoolVar(&cfg.flat, "flat", cfg.flat, "name source fields <source>_id, e.g. drugbank_id, and in enrich mode put them at the top level of each record; json output only")
+	flag.BoolVar(&cfg.forceGzip, "gzip", cfg.forceGzip, "gzip the output even if -output does not end in .gz")
+	flag.StringVar(&cfg.metricsAddr, "metrics-addr", cfg.metricsAddr, "serve Prometheus metrics on this address, e.g. :9090, while running")
+	flag.DurationVar(&cfg.progress, "progress", cfg.progress, "interval between progress reports on stderr; 0 disables them")
+	flag.StringVar(&cfg.api, "api", cfg.api, "UniChem API to query: v1 or legacy")
+	flag.StringVar(&cfg.proxy, "proxy", cfg.proxy, "HTTP proxy URL for all requests; defaults to HTTP_PROXY/HTTPS_PROXY")
+	flag.StringVar(&cfg.unichemURL, "unichem-url", cfg.unichemURL, "base URL of the UniChem web services; defaults to $UNICHEM_URL when set")
+	flag.StringVar(&cfg.inputSource, "input-source", cfg.inputSource, "UniChem src_id of the input IDs; anything other than 1 (ChEMBL) implies -input-format idlist unless -id-field is set")
+	flag.StringVar(&cfg.idField, "id-field", cfg.idField, "record field holding the -input-source ID to look up: chembl_id, drug_name or id, or attributes.<name> for the value of the named attribute")
+	flag.StringVar(&cfg.inputFormat, "input-format", cfg.inputFormat, "input format: ndjson for DGIdb records (newline delimited or a JSON array) or idlist for one ID per line (default ndjson, or idlist with -input-source)")
+	flag.BoolVar(&cfg.enrichGenes, "enrich-genes", cfg.enrichGenes, "add the Ensembl and HGNC IDs of each record's gene from MyGene.info; requires -mode enrich")
+	flag.StringVar(&filterSource, "filter-source", filterSource, "comma separated interaction sources, e.g. DrugBank; only records from one of them are processed")
+	flag.StringVar(&filterType, "filter-interaction-type", filterType, "comma separated interaction types, e.g. inhibitor; only records with one of them are processed")
+	flag.StringVar(&onlyIDs, "only-ids", onlyIDs, "file of ChEMBL IDs, one per line; only records with one of them are processed")
+	flag.BoolVar(&cfg.resolveByName, "resolve-by-name", cfg.resolveByName, "look up a ChEMBL ID by drug name for records that lack one")
+	flag.BoolVar(&cfg.keepFailed, "keep-failed", cfg.keepFailed, "write records whose lookup failed, or that have no ID, unchanged with an error field instead of as a CompoundID; -mode ids with json output only")
+	flag.BoolVar(&cfg.normalizeAttributes, "normalize-attributes", cfg.normalizeAttributes, "merge attributes of a record that share a name, keeping every distinct value and source")
+	flag.BoolVar(&cfg.keepProvenance, "keep-provenance", cfg.keepProvenance, "add the sources and interaction types of each record to its CompoundID; -mode ids with json output only")
+	flag.BoolVar(&cfg.strict, "strict", cfg.strict, "fail if any input record that was not filtered out produced no output")
+	flag.BoolVar(&cfg.skipUnresolved, "skip-unresolved", cfg.skipUnresolved, "drop records that have no ChEMBL ID instead of emitting them with an error")
+	flag.BoolVar(&cfg.skipBadLines, "skip-bad-lines", cfg.skipBadLines, "log and skip input lines that are not valid records instead of aborting")
+	flag.Int64Var(&cfg.limit, "limit", cfg.limit, "stop after reading this many input records; 0 reads them all")
+	flag.BoolVar(&cfg.dedup, "dedup", cfg.dedup, "write each compound once, or in enrich mode each distinct record once")
+	flag.BoolVar(&cfg.withStructure, "with-structure", cfg.withStructure, "add each compound's standard InChIKey; an extra request per compound with -api legacy")
+	flag.BoolVar(&cfg.includeObsolete, "include-obsolete", cfg.includeObsolete, "also list the IDs UniChem has retired for each compound; -api legacy only")
+	flag.BoolVar(&cfg.pubchemFallback, "pubchem-fallback", cfg.pubchemFallback, "look up the drug name in PubChem when UniChem has no PubChem CID; such CIDs are listed in fallbacks")
+	flag.StringVar(&cfg.pubchemURL, "pubchem-url", cfg.pubchemURL, "base URL of the PubChem PUG-REST service used by -pubchem-fallback; defaults to "+defaultPubChemURL)
+	flag.BoolVar(&cfg.connectivity, "connectivity", cfg.connectivity, "add the IDs of compounds sharing each compound's InChIKey connectivity layer; needs -with-structure")
+	flag.BoolVar(&cfg.withSourceMeta, "with-source-meta", cfg.withSourceMeta, "add the UniChem release of each source a compound maps to")
+	flag.BoolVar(&cfg.keepRaw, "keep-raw", cfg.keepRaw, "add the raw UniChem response each compound was mapped from under _raw; makes the output much larger")
+	flag.BoolVar(&cfg.withATC, "with-atc", cfg.withATC, "add each compound's ATC classification codes from ChEMBL; an extra request per compound")
+	flag.BoolVar(&cfg.withRxNorm, "with-rxnorm", cfg.withRxNorm, "add each compound's RxNorm CUI, looked up by DrugBank ID or else by drug name; an extra request per compound")
+	flag.StringVar(&cfg.rxnormURL, "rxnorm-url", cfg.rxnormURL, "base URL of the RxNorm REST API used by -with-rxnorm; defaults to "+defaultRxNormURL)
+	flag.BoolVar(&cfg.withProperties, "with-properties", cfg.withProperties, "add each compound's molecular weight and formula from ChEMBL; shares the request with -with-atc")
+	flag.BoolVar(&cfg.allSources, "all-sources", cfg.allSources, "also record every UniChem mapping, keyed by source name, under all_sources")
+	flag.BoolVar(&cfg.dryRun, "dry-run", cfg.dryRun, "validate the input and count the IDs to look up without querying UniChem or writing output")
+	flag.StringVar(&cfg.manifest, "manifest", cfg.manifest, "record the version, time, endpoint, sources and input of the run: inline writes a leading {\"_manifest\": ...} line, sidecar writes <output>.manifest.json")
+	flag.StringVar(&cfg.reportFile, "report", cfg.reportFile, "write the end of run summary as JSON to this file, or to stderr for -")
+	flag.StringVar(&cfg.reportFile, "stats-json", cfg.reportFile, "same as -report")
+	flag.StringVar(&cfg.checkpointFile, "checkpoint", cfg.checkpointFile, "file recording which input records have been written")
+	flag.DurationVar(&cfg.checkpointInterval, "checkpoint-interval", cfg.checkpointInterval, "how often to update -checkpoint")
+	flag.BoolVar(&cfg.resume, "resume", cfg.resume, "skip the records already written according to -checkpoint and append to -output")
+	flag.StringVar(&cfg.logLevel, "log-level", cfg.logLevel, "minimum level of diagnostics written to stderr: debug, info, warn or error")
+	flag.StringVar(&cfg.logFormat, "log-format", cfg.logFormat, "format of diagnostics on stderr: text or json")
+	flag.BoolVar(&cfg.trace, "trace", cfg.trace, "time every HTTP request, logging each at debug level and latency percentiles in the summary")
+	flag.BoolVar(&cfg.quiet, "quiet", cfg.quiet, "only write fatal errors to stderr; overrides -log-level")
+	flag.Parse()
+
+	if showVersion {
+		fmt.Println("dgidb-transform", versionString())
+		return
+	}
+
+	if cfg.inputFormat == "" {
+		cfg.inputFormat = "ndjson"
+		if cfg.inputSource != "1" && cfg.idField == "chembl_id" {
+			cfg.inputFormat = "idlist"
+		}
+	}
+
+	level := slog.LevelInfo
+	err := level.UnmarshalText([]byte(cfg.logLevel))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-level %q\n", cfg.logLevel)
+		os.Exit(1)
+	}
+	if cfg.quiet {
+		level = slog.LevelError
+	}
+	logOpts := &slog.HandlerOptions{Level: level}
+	var logger *slog.Logger
+	switch cfg.logFormat {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, logOpts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, logOpts))
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -log-format %q\n", cfg.logFormat)
+		os.Exit(1)
+	}
+
+	cfg.filterSource = parseFilter(filterSource)
+	cfg.dgidbGenes = parseNames(dgidbGenes)
+	cfg.dgidbDrugs = parseNames(dgidbDrugs)
+	cfg.filterType = parseFilter(filterType)
+	cfg.sources, err = unichem.ParseSources(sourceList)
+	if err == nil {
+		cfg.sourceRate, err = parseServiceLimits("-source-rate", sourceRate, func(v string) (float64, error) {
+			return strconv.ParseFloat(v, 64)
+		})
+	}
+	if err == nil {
+		cfg.sourceConcurrency, err = parseServiceLimits("-source-concurrency", sourceConcurrency, strconv.Atoi)
+	}
+	if err == nil && onlyIDs != "" {
+		cfg.onlyIDs, err = readAllowlist(cfg, onlyIDs)
+	}
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err == nil && cfg.dryRun {
+		err = dryRun(cfg, logger)
+	} else if err == nil {
+		err = run(cfg, logger)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// validate checks option combinations that cannot work together.
+func (cfg config) validate() error {
+	if cfg.mode != "ids" && cfg.mode != "enrich" {
+		return fmt.Errorf("unknown -mode %q; expected ids or enrich", cfg.mode)
+	}
+
+	switch cfg.outputFormat {
+	case "json", "jsonpb":
+	case "tsv", "csv", "map":
+		if cfg.mode != "ids" {
+			return fmt.Errorf("-output-format %s only supports -mode ids", cfg.outputFormat)
+		}
+	default:
+		return fmt.Errorf("unknown -output-format %q; expected json, jsonpb, tsv, csv or map", cfg.outputFormat)
+	}
+
+	switch cfg.inputFormat {
+	case "ndjson":
+		if cfg.inputSource != "1" && cfg.idField == "chembl_id" {
+			return fmt.Errorf("-input-source %s needs -input-format idlist or an -id-field holding its IDs", cfg.inputSource)
+		}
+	case "idlist":
+		if cfg.idField != "chembl_id" {
+			return fmt.Errorf("-id-field needs -input-format ndjson records")
+		}
+	default:
+		return fmt.Errorf("unknown -input-format %q; expected ndjson or idlist", cfg.inputFormat)
+	}
+
+	if cfg.fromDGIdb && (cfg.inputFile != "" || cfg.inputFormat != "ndjson") {
+		return fmt.Errorf("-from-dgidb fetches ndjson records and cannot be combined with -input or -input-format idlist")
+	}
+	if (len(cfg.dgidbGenes) > 0 || len(cfg.dgidbDrugs) > 0) && !cfg.fromDGIdb {
+		return fmt.Errorf("-dgidb-genes and -dgidb-drugs need -from-dgidb")
+	}
+
+	if !validIDField(cfg.idField) {
+		return fmt.Errorf("unknown -id-field %q; expected chembl_id, drug_name, id or attributes.<name>", cfg.idField)
+	}
+	// Drug names are never ChEMBL IDs, so every lookup would fail.
+	if cfg.idField == "drug_name" && cfg.inputSource == "1" {
+		return fmt.Errorf("-id-field drug_name holds names, not ChEMBL IDs; set -input-source to the UniChem source the names belong to")
+	}
+
+	if (len(cfg.filterSource) > 0 || len(cfg.filterType) > 0) && cfg.inputFormat != "ndjson" {
+		return fmt.Errorf("-filter-source and -filter-interaction-type need -input-format ndjson records")
+	}
+
+	if cfg.onlyIDs != nil && cfg.inputSource != "1" {
+		return fmt.Errorf("-only-ids needs ChEMBL input IDs; -input-source is %s", cfg.inputSource)
+	}
+
+	switch cfg.manifest {
+	case "":
+	case "inline":
+		if cfg.outputFormat != "json" && cfg.outputFormat != "jsonpb" {
+			return fmt.Errorf("-manifest inline needs json or jsonpb output; use -manifest sidecar")
+		}
+	case "sidecar":
+		if cfg.outputFile == "" {
+			return fmt.Errorf("-manifest sidecar needs an -output file")
+		}
+	default:
+		return fmt.Errorf("unknown -manifest %q; expected inline or sidecar", cfg.manifest)
+	}
+
+	if cfg.keepFailed && (cfg.mode != "ids" || cfg.outputFormat != "json" || cfg.inputFormat != "ndjson") {
+		return fmt.Errorf("-keep-failed only supports ndjson input with -mode ids and -output-format json; enrich mode already keeps the record")
+	}
+
+	if (cfg.resume || cfg.appendOutput) && cfg.outputFormat == "map" {
+		return fmt.Errorf("-resume and -append do not support -output-format map")
+	}
+
+	if cfg.appendOutput && cfg.outputFile == "" {
+		return fmt.Errorf("-append needs an -output file")
+	}
+
+	if cfg.split < 0 {
+		return fmt.Errorf("-split must not be negative")
+	}
+	if cfg.splitBy != "record" && cfg.splitBy != "key" {
+		return fmt.Errorf("unknown -split-by %q; expected record or key", cfg.splitBy)
+	}
+	if cfg.split > 0 {
+		if cfg.outputFile == "" {
+			return fmt.Errorf("-split needs an -output file to number")
+		}
+		if cfg.resume || cfg.appendOutput || cfg.checkpointFile != "" {
+			return fmt.Errorf("-split cannot be combined with -checkpoint, -resume or -append")
+		}
+		if cfg.outputFormat == "map" || cfg.manifest == "inline" {
+			return fmt.Errorf("-split cannot be combined with -output-format map or -manifest inline")
+		}
+	}
+
+	if cfg.normalizeAttributes && cfg.inputFormat != "ndjson" {
+		return fmt.Errorf("-normalize-attributes needs -input-format ndjson records")
+	}
+
+	if cfg.keepProvenance && (cfg.mode != "ids" || cfg.outputFormat != "json" || cfg.inputFormat != "ndjson") {
+		return fmt.Errorf("-keep-provenance only supports ndjson input with -mode ids and -output-format json; enrich mode already keeps the record")
+	}
+
+	// Each of these drops records by design, which -strict would report.
+	if cfg.strict && (cfg.dedup || cfg.skipUnresolved || cfg.emitUnresolved || cfg.outputFormat == "map") {
+		return fmt.Errorf("-strict cannot be combined with -dedup, -skip-unresolved, -emit-unresolved or -output-format map, which drop records")
+	}
+
+	if cfg.emitUnresolved {
+		if cfg.mode != "ids" || cfg.inputSource != "1" {
+			return fmt.Errorf("-emit-unresolved only supports -mode ids with ChEMBL input IDs")
+		}
+		if cfg.outputFormat != "json" || cfg.flat || cfg.keepFailed || cfg.keepProvenance || cfg.keepRaw || cfg.manifest == "inline" {
+			return fmt.Errorf("-emit-unresolved writes plain ChEMBL IDs and cannot be combined with other output options")
+		}
+	}
+
+	if cfg.keepRaw && (cfg.outputFormat == "tsv" || cfg.outputFormat == "csv") {
+		return fmt.Errorf("-keep-raw needs json, jsonpb or map output")
+	}
+
+	if cfg.pretty && cfg.outputFormat != "map" {
+		return fmt.Errorf("-pretty only supports -output-format map; indenting would break newline delimited output")
+	}
+
+	if cfg.flat && cfg.outputFormat != "json" {
+		return fmt.Errorf("-flat only supports -output-format json")
+	}
+
+	if cfg.pubchemFallback && (!cfg.sources["22"] || cfg.inputFormat != "ndjson") {
+		return fmt.Errorf("-pubchem-fallback needs pubchem among -sources and -input-format ndjson records with drug names")
+	}
+
+	if cfg.withRxNorm && !cfg.sources["2"] && cfg.inputFormat != "ndjson" {
+		return fmt.Errorf("-with-rxnorm needs drugbank among -sources or -input-format ndjson records with drug names")
+	}
+
+	if cfg.connectivity && !cfg.withStructure {
+		return fmt.Errorf("-connectivity needs -with-structure for the InChIKey to search with")
+	}
+
+	if cfg.enrichGenes && cfg.mode != "enrich" {
+		return fmt.Errorf("-enrich-genes requires -mode enrich")
+	}
+
+	if cfg.api != "v1" && cfg.api != "legacy" {
+		return fmt.Errorf("unknown -api %q; expected v1 or legacy", cfg.api)
+	}
+
+	if cfg.includeObsolete && cfg.api != "legacy" {
+		return fmt.Errorf("-include-obsolete needs -api legacy; the v1 API only returns current mappings")
+	}
+
+	if u, err := url.Parse(cfg.unichemURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -unichem-url %q; expected an absolute http or https URL", cfg.unichemURL)
+	}
+
+	if cfg.proxy != "" {
+		if u, err := url.Parse(cfg.proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid -proxy %q; expected a URL such as http://proxy:3128", cfg.proxy)
+		}
+	}
+
+	if _, ok := unichem.KnownSources[cfg.inputSource]; !ok && cfg.inputSource != "1" {
+		return fmt.Errorf("unknown -input-source %q", cfg.inputSource)
+	}
+
+	if cfg.resume && (cfg.checkpointFile == "" || cfg.outputFile == "" || cfg.gzipOutput()) {
+		return fmt.Errorf("-resume requires -checkpoint and an uncompressed -output file")
+	}
+
+	if cfg.limit < 0 {
+		return fmt.Errorf("-limit must not be negative")
+	}
+
+	if cfg.threads < 1 {
+		return fmt.Errorf("-threads must be at least 1")
+	}
+
+	if cfg.maxIdleConns < 0 || cfg.maxConnsPerHost < 0 || cfg.idleTimeout < 0 {
+		return fmt.Errorf("-max-idle-conns, -max-conns-per-host and -idle-timeout must not be negative")
+	}
+
+	if cfg.retries < 0 {
+		return fmt.Errorf("-retries must not be negative")
+	}
+
+	if cfg.emptyRetries < 0 || cfg.emptyThreshold < 1 {
+		return fmt.Errorf("-empty-retries must not be negative and -empty-threshold must be at least 1")
+	}
+
+	if !(cfg.rate >= 0) {
+		return fmt.Errorf("-rate must not be negative")
+	}
+
+	if cfg.maxResponseSize < 1 {
+		return fmt.Errorf("-max-response-size must be at least 1")
+	}
+
+	return nil
+}
+
+// cacheOptions describes the options that shape the CompoundIDs a UniChem
+// lookup returns, which -cache-dir entries must have been written with.
+func (cfg config) cacheOptions() string {
+	sources := []string{}
+	for id := range cfg.sources {
+		sources = append(sources, id)
+	}
+	return fmt.Sprintf("api=%s sources=%s all-sources=%t with-structure=%t with-source-meta=%t include-obsolete=%t keep-raw=%t",
+		cfg.api, strings.Join(unichem.SortSourceIDs(sources), ","), cfg.allSources, cfg.withStructure, cfg.withSourceMeta, cfg.includeObsolete, cfg.keepRaw)
+}
+
+func (cfg config) gzipOutput() bool {
+	return cfg.forceGzip || strings.HasSuffix(cfg.outputFile, ".gz")
+}
+
+// openInput opens name, or stdin when it is empty. An http or https URL
+// is streamed from the server with the client and -timeout of cfg, which
+// bounds the wait for the response rather than the whole download. Compressed input is detected from its
+// content, which covers .gz files. The returned reader records a read that
+// fails before the end of the input.
+func openInput(cfg config, name string) (*streamReader, io.Closer, error) {
+	var file io.ReadCloser = os.Stdin
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		client, err := cfg.httpClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, "GET", name, nil)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		req.Header.Set("User-Agent", userAgent())
+		if cfg.timeout > 0 {
+			timer := time.AfterFunc(cfg.timeout, cancel)
+			defer timer.Stop()
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			return nil, nil, fmt.Errorf("fetching input: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			cancel()
+			return nil, nil, fmt.Errorf("fetching input %s: %s", name, resp.Status)
+		}
+		file = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	} else if name != "" {
+		var err error
+		file, err = os.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	input, err := gzipReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("reading input: %w", err)
+	}
+	return &streamReader{r: input}, file, nil
+}
+
+// cancelReadCloser releases the request a response body belongs to once
+// the body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// dryRun reads and validates the whole input without contacting UniChem or
+// writing output, and logs how many lookups a real run would make.
+func dryRun(cfg config, logger *slog.Logger) error {
+	var input *streamReader
+	var err error
+	if !cfg.fromDGIdb {
+		var file io.Closer
+		input, file, err = openInput(cfg, cfg.inputFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	records, unresolved, invalid, badLines, filtered := 0, 0, 0, 0, 0
+	keys := map[string]bool{}
+	check := func(id string) {
+		records++
+		if id == "" {
+			unresolved++
+			return
+		}
+		if cfg.inputSource == "1" {
+			normalized, err := unichem.NormalizeChEMBLID(id)
+			if err != nil {
+				invalid++
+				logger.Warn("invalid input ID", "record", records, "err", err)
+				return
+			}
+			id = normalized
+		}
+		keys[id] = true
+	}
+	badLine := func(line int, err error) error {
+		badLines++
+		logger.Warn("bad input line", "line", line, "err", err)
+		return nil
+	}
+	record := func(interaction Record) error {
+		if !matchesFilter(cfg.filterSource, interaction.Sources) || !matchesFilter(cfg.filterType, interaction.InteractionTypes) || !allowlisted(cfg.onlyIDs, recordID(interaction, cfg.idField)) {
+			filtered++
+			return nil
+		}
+		id := recordID(interaction, cfg.idField)
+		if id == "" {
+			if inchikey := recordInChIKey(interaction); inchikey != "" {
+				id = "inchikey-" + inchikey
+				records++
+				keys[id] = true
+				return nil
+			}
+		}
+		check(id)
+		return nil
+	}
+	if cfg.fromDGIdb {
+		fetcher, err := cfg.fetcher()
+		if err != nil {
+			return err
+		}
+		dgidbAPI := &DGIdb{Fetcher: fetcher, URL: cfg.dgidbURL, Logger: logger}
+		err = dgidbAPI.Interactions(context.Background(), cfg.dgidbGenes, cfg.dgidbDrugs, record)
+	} else if cfg.inputFormat == "ndjson" {
+		err = readRecords(input, cfg.maxLine, badLine, record)
+	} else {
+		err = readIDs(input, cfg.maxLine, func(id string) error {
+			if !allowlisted(cfg.onlyIDs, id) {
+				filtered++
+				return nil
+			}
+			check(id)
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	logger.Info("dry run", "records", records, "unique_ids", len(keys), "unresolved", unresolved, "invalid_ids", invalid, "bad_lines", badLines, "filtered", filtered)
+	if badLines > 0 && !cfg.skipBadLines {
+		return fmt.Errorf("%d bad input lines", badLines)
+	}
+	return nil
+}
+
+// run resolves every input record. Per-record lookup failures are logged and
+// recorded in the output; the returned error is reserved for failures that
+// stop the run.
+func run(cfg config, logger *slog.Logger) (err error) {
+	cp := checkpoint{}
+	// rewind is set when a checkpoint says how much of the output to keep.
+	rewind := false
+	if cfg.resume {
+		cp, err = loadCheckpoint(cfg.checkpointFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("reading checkpoint %s: %w", cfg.checkpointFile, err)
+		}
+		// Without -append a missing checkpoint is a fresh start that
+		// rewinds the output to nothing.
+		rewind = err == nil || !cfg.appendOutput
+		err = nil
+	}
+
+	var out io.WriteCloser
+	// parts holds the files of a -split output, which replace out.
+	var parts []io.WriteCloser
+	defer func() {
+		for _, part := range parts {
+			if cerr := part.Close(); err == nil && cerr != nil {
+				err = fmt.Errorf("closing output: %w", cerr)
+			}
+		}
+	}()
+	if cfg.outputFile != "" {
+		outputFile, err := filepath.Abs(cfg.outputFile)
+		if err != nil {
+			return err
+		}
+		// Only the last directory of the path is created, so a mistyped
+		// path fails instead of growing a new tree.
+		d := filepath.Dir(outputFile)
+		if _, err := os.Stat(d); os.IsNotExist(err) {
+			if cfg.noCreateDirs {
+				return fmt.Errorf("output directory %s does not exist", d)
+			}
+			err = os.Mkdir(d, 0755)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("output directory %s does not exist and neither does its parent", d)
+			}
+			if err != nil {
+				return fmt.Errorf("creating output directory: %w", err)
+			}
+		}
+		if rewind {
+			// Drop anything written after the checkpoint so those records
+			// are not duplicated when they are processed again.
+			f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			err = f.Truncate(cp.OutputBytes)
+			if err == nil {
+				_, err = f.Seek(0, io.SeekEnd)
+			}
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("rewinding output to checkpoint: %w", err)
+			}
+			out = f
+		} else if cfg.appendOutput {
+			f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			info, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return err
+			}
+			// Checkpoints count the existing output, so a resumed run
+			// rewinds to the end of this one rather than the start.
+			cp.OutputBytes = info.Size()
+			out = f
+		} else if cfg.split > 0 {
+			for i := 0; i < cfg.split; i++ {
+				f, err := createOutput(splitName(outputFile, i), cfg.force)
+				if err != nil {
+					return err
+				}
+				var part io.WriteCloser = f
+				if cfg.gzipOutput() {
+					part = &gzipWriteCloser{Writer: gzip.NewWriter(f), out: f}
+				}
+				parts = append(parts, part)
+			}
+		} else {
+			out, err = createOutput(outputFile, cfg.force)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		out = os.Stdout
+	}
+	counted := &countingWriter{WriteCloser: out, n: cp.OutputBytes}
+	if out != nil {
+		out = counted
+		if cfg.gzipOutput() {
+			out = &gzipWriteCloser{Writer: gzip.NewWriter(out), out: out}
+		}
+		defer func() {
+			if cerr := out.Close(); err == nil && cerr != nil {
+				err = fmt.Errorf("closing output: %w", cerr)
+			}
+		}()
+	}
+
+	switch cfg.manifest {
+	case "inline":
+		// A resumed run appends to output that already starts with one.
+		if cp.OutputBytes == 0 {
+			err = json.NewEncoder(out).Encode(map[string]manifest{"_manifest": newManifest(cfg)})
+		}
+	case "sidecar":
+		var body []byte
+		body, err = json.MarshalIndent(newManifest(cfg), "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(cfg.outputFile+".manifest.json", append(body, '\n'), 0644)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	var input *streamReader
+	if !cfg.fromDGIdb {
+		var file io.Closer
+		input, file, err = openInput(cfg, cfg.inputFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// fail records the first error that stops the run and cancels the
+	// remaining work.
+	var failMu sync.Mutex
+	var failErr error
+	fail := func(err error) {
+		failMu.Lock()
+		if failErr == nil {
+			failErr = err
+		}
+		failMu.Unlock()
+		cancel()
+	}
+
+	fetcher, err := cfg.fetcher()
+	if err != nil {
+		return err
+	}
+	var latency *latencies
+	if cfg.trace {
+		latency = &latencies{}
+		fetcher.Trace = func(method, url string, elapsed time.Duration, err error) {
+			latency.add(elapsed)
+			logger.Debug("request", "method", method, "url", url, "elapsed", elapsed, "err", err)
+		}
+	}
+	// limit copies fetcher for service with its own request rate, rate
+	// unless -source-rate names service, and its own -source-concurrency.
+	// Each copy counts its own requests, which fetchers keeps for the
+	// metrics.
+	fetchers := map[string]*unichem.Fetcher{}
+	var tickers []*time.Ticker
+	defer func() {
+		for _, ticker := range tickers {
+			ticker.Stop()
+		}
+	}()
+	limit := func(service string, rate float64) *unichem.Fetcher {
+		f := *fetcher
+		if r, ok := cfg.sourceRate[service]; ok {
+			rate = r
+		}
+		if ticker := rateTicker(rate); ticker != nil {
+			tickers = append(tickers, ticker)
+			f.Limiter = ticker.C
+		}
+		if n := cfg.sourceConcurrency[service]; n > 0 {
+			f.Slots = make(chan struct{}, n)
+		}
+		fetchers[service] = &f
+		return &f
+	}
+	ucFetcher := limit("unichem", cfg.rate)
+	uc := &unichem.Client{
+		Fetcher:         ucFetcher,
+		API:             cfg.api,
+		BaseURL:         cfg.unichemURL,
+		Sources:         cfg.sources,
+		AllSources:      cfg.allSources,
+		WithStructure:   cfg.withStructure,
+		WithSourceMeta:  cfg.withSourceMeta,
+		KeepRaw:         cfg.keepRaw,
+		EmptyRetries:    cfg.emptyRetries,
+		IncludeObsolete: cfg.includeObsolete,
+		MinMappings:     cfg.emptyThreshold,
+		Logger:          logger,
+	}
+	// ChEMBL shares the UniChem limits unless it is given its own.
+	var chemblFetcher *unichem.Fetcher
+	if _, ok := cfg.sourceRate["chembl"]; ok || cfg.sourceConcurrency["chembl"] > 0 {
+		chemblFetcher = limit("chembl", cfg.rate)
+	} else {
+		f := *ucFetcher
+		chemblFetcher = &f
+		fetchers["chembl"] = chemblFetcher
+	}
+	chembl := &ChEMBL{Fetcher: chemblFetcher}
+	// MyGene.info is not subject to the UniChem request rate.
+	mygene := &MyGene{Fetcher: limit("mygene", 0)}
+	// PubChem asks clients to stay under five requests per second.
+	pubchemRate := 0.0
+	if cfg.pubchemFallback {
+		pubchemRate = 5
+	}
+	pubchem := &PubChem{Fetcher: limit("pubchem", pubchemRate), BaseURL: cfg.pubchemURL}
+	dgidbAPI := &DGIdb{Fetcher: limit("dgidb", 0), URL: cfg.dgidbURL, Logger: logger}
+	// NLM asks clients to stay under twenty requests per second.
+	rxnormRate := 0.0
+	if cfg.withRxNorm {
+		rxnormRate = 20
+	}
+	rxnorm := &RxNorm{Fetcher: limit("rxnorm", rxnormRate), BaseURL: cfg.rxnormURL}
+
+	var disk *diskCache
+	if cfg.cacheDir != "" {
+		err = os.MkdirAll(cfg.cacheDir, 0755)
+		if err != nil {
+			return fmt.Errorf("creating cache directory: %w", err)
+		}
+		disk = &diskCache{dir: cfg.cacheDir, ttl: cfg.cacheTTL, options: cfg.cacheOptions()}
+	}
+
+	start := time.Now()
+	stats := newCounters(cfg.sources)
+	stats.latency = latency
+	if cfg.progress > 0 {
+		ticker := time.NewTicker(cfg.progress)
+		defer ticker.Stop()
+		go func() {
+			for range ticker.C {
+				logger.Info(stats.report(start))
+			}
+		}()
+	}
+	if cfg.metricsAddr != "" {
+		ln, err := net.Listen("tcp", cfg.metricsAddr)
+		if err != nil {
+			return fmt.Errorf("serving metrics: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			stats.writeMetrics(w, fetchers)
+		})
+		srv := &http.Server{Handler: mux}
+		defer srv.Close()
+		go srv.Serve(ln)
+	}
+
+	cache := newLookupCache[string, unichem.CompoundID]()
+	names := newLookupCache[string, unichem.CompoundID]()
+	genes := newLookupCache[int64, GeneID]()
+	molecules := newLookupCache[string, chemblMolecule]()
+	related := newLookupCache[string, map[string][]string]()
+	cids := newLookupCache[string, string]()
+	rxcuis := newLookupCache[string, []string]()
+	// resolve consults the disk cache under key before calling lookup.
+	resolve := func(key string, lookup func() (unichem.CompoundID, error)) (unichem.CompoundID, error) {
+		if disk != nil && !cfg.cacheRefresh {
+			if cid, ok := disk.load(key); ok {
+				atomic.AddInt64(&stats.cacheHits, 1)
+				return cid, nil
+			}
+		}
+		cid, err := lookup()
+		if err == nil && disk != nil {
+			if err := disk.store(key, cid); err != nil {
+				logger.Warn("writing cache entry", "key", key, "err", err)
+			}
+		}
+		return cid, err
+	}
+
+	// newWriter returns the writer of the output format for out, which
+	// already holds the header row when header is set.
+	newWriter := func(out io.Writer, header bool) recordWriter {
+		if cfg.emitUnresolved {
+			return newUnresolvedWriter(out, cfg.sources)
+		}
+		switch cfg.outputFormat {
+		case "tsv", "csv":
+			comma := ','
+			if cfg.outputFormat == "tsv" {
+				comma = '\t'
+			}
+			cw := newCSVWriter(out, comma, cfg.sources)
+			cw.header = header
+			return cw
+		case "map":
+			return &mapWriter{w: out, pretty: cfg.pretty, seen: map[string]bool{}}
+		case "jsonpb":
+			return &jsonpbWriter{w: out, enrich: cfg.mode == "enrich"}
+		}
+		return &jsonWriter{enc: json.NewEncoder(out), enrich: cfg.mode == "enrich", flat: cfg.flat, keepFailed: cfg.keepFailed, provenance: cfg.keepProvenance}
+	}
+	// pending holds the -strict records queued but not yet emitted, by
+	// sequence number; it is guarded by writerMu.
+	pending := map[int64]string{}
+	strict := func(w recordWriter) recordWriter {
+		if !cfg.strict {
+			return w
+		}
+		return &strictWriter{recordWriter: w, pending: pending}
+	}
+	var writer recordWriter
+	if parts != nil {
+		split := &splitWriter{byKey: cfg.splitBy == "key"}
+		for _, part := range parts {
+			split.parts = append(split.parts, strict(newWriter(part, false)))
+		}
+		writer = split
+	} else {
+		writer = strict(newWriter(out, cp.OutputBytes > 0))
+	}
+	// mapWriter already drops duplicates.
+	if cfg.dedup && cfg.outputFormat != "map" {
+		writer = &dedupWriter{recordWriter: writer, enrich: cfg.mode == "enrich", seen: map[string]bool{}}
+	}
+
+	// writerMu keeps concurrent writes from interleaving and guards done,
+	// so a checkpoint always matches what has reached the output.
+	var writerMu sync.Mutex
+	done := newCompletion(cp)
+	// With -ordered, results wait in buffered until every earlier record
+	// is finished, and a slot is held from queueing to writing so that at
+	// most orderedWindow records are in flight or buffered.
+	type result struct {
+		j   job
+		cid *unichem.CompoundID
+	}
+	buffered := map[int64]result{}
+	var slots chan struct{}
+	if cfg.ordered {
+		slots = make(chan struct{}, orderedWindow)
+	}
+	write := func(j job, cid *unichem.CompoundID) bool {
+		if cid != nil {
+			err := writer.Write(EnrichedRecord{Record: j.interaction, Compound: *cid, Gene: j.gene, seq: j.seq})
+			if err != nil {
+				fail(fmt.Errorf("writing output: %w", err))
+				return false
+			}
+		}
+		done.finish(j.seq)
+		return true
+	}
+	// drain writes the buffered results that are next in input order.
+	drain := func() {
+		for {
+			r, ok := buffered[done.next]
+			if !ok {
+				return
+			}
+			delete(buffered, r.j.seq)
+			if !write(r.j, r.cid) {
+				return
+			}
+			<-slots
+		}
+	}
+	emit := func(j job, cid *unichem.CompoundID) {
+		writerMu.Lock()
+		defer writerMu.Unlock()
+		if !cfg.ordered {
+			write(j, cid)
+			return
+		}
+		buffered[j.seq] = result{j: j, cid: cid}
+		drain()
+	}
+	saveCheckpoint := func() error {
+		writerMu.Lock()
+		defer writerMu.Unlock()
+		err := writer.Flush()
+		if err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+		if f, ok := out.(interface{ Flush() error }); ok {
+			err = f.Flush()
+			if err != nil {
+				return fmt.Errorf("writing output: %w", err)
+			}
+		}
+		err = done.checkpoint(counted.n).save(cfg.checkpointFile)
+		if err != nil {
+			logger.Warn("saving checkpoint", "path", cfg.checkpointFile, "err", err)
+		}
+		return nil
+	}
+	if cfg.checkpointFile != "" {
+		ticker := time.NewTicker(cfg.checkpointInterval)
+		defer ticker.Stop()
+		go func() {
+			for range ticker.C {
+				if err := saveCheckpoint(); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	handle := func(j job) {
+		if cfg.enrichGenes && j.interaction.EntrezID != 0 {
+			entrezID := j.interaction.EntrezID
+			gene, cached, err := genes.get(entrezID, func() (GeneID, error) {
+				return mygene.GetGeneIDs(ctx, entrezID)
+			})
+			if err != nil && !cached {
+				logger.Warn("resolving gene", "entrez_id", entrezID, "err", err)
+			}
+			j.gene = &gene
+		}
+		if j.id == "" && cfg.resolveByName && j.interaction.DrugName != "" {
+			name := j.interaction.DrugName
+			named, _, err := names.get(strings.ToUpper(name), func() (unichem.CompoundID, error) {
+				chemblID, err := chembl.ChEMBLIDByName(ctx, name)
+				return unichem.CompoundID{ChEMBL: chemblID}, err
+			})
+			if err != nil {
+				logger.Warn("resolving drug name", "drug", name, "err", err)
+			}
+			j.id = named.ChEMBL
+		}
+		id := j.id
+		key := id
+		lookup := func() (unichem.CompoundID, error) {
+			return uc.GetCompoundIDsBySource(ctx, id, cfg.inputSource)
+		}
+		if cfg.inputSource != "1" {
+			key = cfg.inputSource + "-" + id
+		} else if normalized, err := unichem.NormalizeChEMBLID(id); err == nil {
+			// Spellings of one ChEMBL ID, such as chembl25, share an entry.
+			key = normalized
+		}
+		// Records without a ChEMBL ID fall back to an InChIKey attribute
+		// when they carry one.
+		inchikey := recordInChIKey(j.interaction)
+		if id == "" && inchikey != "" {
+			key = "inchikey-" + inchikey
+			lookup = func() (unichem.CompoundID, error) {
+				return uc.GetCompoundIDsByInChIKey(ctx, inchikey)
+			}
+		}
+		if id == "" && inchikey == "" {
+			atomic.AddInt64(&stats.records, 1)
+			atomic.AddInt64(&stats.unresolved, 1)
+			if cfg.skipUnresolved {
+				emit(j, nil)
+				return
+			}
+			msg := "record has no ChEMBL ID"
+			if cfg.idField != "chembl_id" {
+				msg = "record has no " + cfg.idField
+			}
+			emit(j, &unichem.CompoundID{Error: msg})
+			return
+		}
+		cid, cached, err := cache.get(key, func() (unichem.CompoundID, error) {
+			return resolve(key, lookup)
+		})
+		if err != nil && ctx.Err() != nil {
+			// Cut short by a shutdown or an earlier failure; leave the
+			// record unwritten so a resumed run looks it up again.
+			return
+		}
+		atomic.AddInt64(&stats.records, 1)
+		if cached {
+			atomic.AddInt64(&stats.cacheHits, 1)
+		}
+		if err != nil {
+			atomic.AddInt64(&stats.errors, 1)
+			if cfg.failFast {
+				fail(fmt.Errorf("lookup failed for %s: %w", key, err))
+				return
+			}
+			logger.Warn("lookup failed", "key", key, "err", err)
+			cid.Error = err.Error()
+		} else {
+			stats.countResolved(cid)
+		}
+		if cfg.pubchemFallback && err == nil && cid.PubChem == "" && j.interaction.DrugName != "" {
+			name := j.interaction.DrugName
+			pubchemID, cached, err := cids.get(strings.ToUpper(name), func() (string, error) {
+				return pubchem.CIDByName(ctx, name)
+			})
+			if err != nil && !cached {
+				logger.Warn("resolving drug name in PubChem", "drug", name, "err", err)
+			}
+			if pubchemID != "" {
+				cid.PubChem = pubchemID
+				cid.Fallbacks = append(cid.Fallbacks, "pubchem")
+			}
+		}
+		if cfg.connectivity && cid.InChIKey != "" {
+			inchikey := cid.InChIKey
+			ids, cached, err := related.get(inchikey, func() (map[string][]string, error) {
+				return uc.GetConnectivity(ctx, inchikey)
+			})
+			if err != nil && !cached {
+				logger.Warn("searching connectivity", "inchikey", inchikey, "err", err)
+			}
+			cid.Connectivity = ids
+		}
+		if (cfg.withATC || cfg.withProperties) && cid.ChEMBL != "" {
+			chemblID := cid.ChEMBL
+			mol, cached, err := molecules.get(chemblID, func() (chemblMolecule, error) {
+				return chembl.Molecule(ctx, chemblID)
+			})
+			if err != nil && !cached {
+				logger.Warn("fetching ChEMBL molecule", "chembl", chemblID, "err", err)
+			}
+			if cfg.withATC {
+				cid.ATC = mol.ATCClassifications
+			}
+			if cfg.withProperties && mol.Properties != nil {
+				cid.MolecularFormula = mol.Properties.MolecularFormula
+				if mol.Properties.FullMWT != "" {
+					mwt, err := mol.Properties.FullMWT.Float64()
+					if err != nil {
+						logger.Warn("parsing ChEMBL molecular weight", "chembl", chemblID, "full_mwt", mol.Properties.FullMWT, "err", err)
+					}
+					cid.FullMWT = mwt
+				}
+			}
+		}
+		if cfg.withRxNorm && err == nil {
+			var cuis []string
+			var cached bool
+			var err error
+			if cid.DrugBank != "" {
+				drugBankID := cid.DrugBank
+				cuis, cached, err = rxcuis.get(drugBankID, func() ([]string, error) {
+					return rxnorm.CUIsByDrugBankID(ctx, drugBankID)
+				})
+			} else if name := j.interaction.DrugName; name != "" {
+				cuis, cached, err = rxcuis.get("name:"+strings.ToUpper(name), func() ([]string, error) {
+					return rxnorm.CUIsByName(ctx, name)
+				})
+			}
+			if err != nil && !cached {
+				logger.Warn("resolving RxNorm CUI", "drugbank", cid.DrugBank, "drug", j.interaction.DrugName, "err", err)
+			}
+			if len(cuis) > 0 {
+				cid.RxNorm = cuis[0]
+			}
+			if len(cuis) > 1 {
+				// IDs may be shared with the cached compound.
+				ids := maps.Clone(cid.IDs)
+				if ids == nil {
+					ids = map[string][]string{}
+				}
+				ids["rxnorm"] = cuis
+				cid.IDs = ids
+			}
+		}
+		emit(j, &cid)
+	}
+
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				handle(j)
+			}
+		}()
+	}
+
+	// A first SIGINT or SIGTERM stops the read and gives the lookups in
+	// flight shutdownGrace to finish before they are cancelled; the output
+	// and checkpoint are still flushed. A second signal kills the process.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	stopping := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			logger.Warn("shutting down", "signal", sig.String(), "grace", shutdownGrace)
+			close(stopping)
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(shutdownGrace):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// queue numbers each input record and hands it to the workers unless a
+	// resumed checkpoint shows it was already written. It stops the read
+	// once the run has failed or is shutting down.
+	seq := int64(0)
+	queue := func(j job) error {
+		if cfg.limit > 0 && seq >= cfg.limit {
+			return errLimit
+		}
+		j.seq = seq
+		seq++
+		filtered := !matchesFilter(cfg.filterSource, j.interaction.Sources) ||
+			!matchesFilter(cfg.filterType, j.interaction.InteractionTypes) ||
+			!allowlisted(cfg.onlyIDs, j.id)
+		writerMu.Lock()
+		skip := done.finished(j.seq)
+		if filtered && !skip {
+			done.finish(j.seq)
+			if cfg.ordered {
+				drain()
+			}
+		}
+		if cfg.strict && !filtered && !skip {
+			pending[j.seq] = j.id
+		}
+		writerMu.Unlock()
+		if filtered {
+			atomic.AddInt64(&stats.filtered, 1)
+		}
+		if skip || filtered {
+			return nil
+		}
+		select {
+		case <-stopping:
+			return errInterrupted
+		default:
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-stopping:
+				return errInterrupted
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case jobs <- j:
+			return nil
+		case <-stopping:
+			return errInterrupted
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	var badLine func(line int, err error) error
+	if cfg.skipBadLines {
+		badLine = func(line int, err error) error {
+			atomic.AddInt64(&stats.badLines, 1)
+			logger.Warn("skipping bad input line", "line", line, "err", err)
+			return nil
+		}
+	}
+	record := func(interaction Record) error {
+		if cfg.normalizeAttributes {
+			normalizeAttributes(&interaction)
+		}
+		return queue(job{interaction: interaction, id: recordID(interaction, cfg.idField)})
+	}
+	if cfg.fromDGIdb {
+		err = dgidbAPI.Interactions(ctx, cfg.dgidbGenes, cfg.dgidbDrugs, record)
+	} else if cfg.inputFormat == "ndjson" {
+		err = readRecords(input, cfg.maxLine, badLine, record)
+	} else {
+		err = readIDs(input, cfg.maxLine, func(id string) error {
+			return queue(job{id: id})
+		})
+	}
+	interrupted := err == errInterrupted
+	// A stream that stops early keeps what was read before the break, so
+	// the records up to it are written and checkpointed like an interrupt.
+	truncated := err != nil && ctx.Err() == nil && input != nil &&
+		(input.err != nil || errors.Is(err, io.ErrUnexpectedEOF))
+	if truncated {
+		logger.Error("reading input", "err", err)
+	}
+	if err == errLimit || interrupted || truncated {
+		err = nil
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil && ctx.Err() == nil {
+		fail(fmt.Errorf("reading input: %w", err))
+	}
+	failMu.Lock()
+	err = failErr
+	failMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if cfg.checkpointFile != "" {
+		err = saveCheckpoint()
+	} else if err = writer.Flush(); err != nil {
+		err = fmt.Errorf("writing output: %w", err)
+	}
+	if c, ok := writer.(io.Closer); ok && err == nil {
+		if err = c.Close(); err != nil {
+			err = fmt.Errorf("writing output: %w", err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	report := stats.summary(start)
+	logger.Info(report.String())
+	if cfg.reportFile == "-" {
+		// One line among the diagnostics is easier to pick out.
+		body, err := json.Marshal(report)
+		if err == nil {
+			_, err = fmt.Fprintf(os.Stderr, "%s\n", body)
+		}
+		if err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	} else if cfg.reportFile != "" {
+		body, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(cfg.reportFile, append(body, '\n'), 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+	}
+	if interrupted {
+		if cfg.checkpointFile != "" {
+			return fmt.Errorf("interrupted; rerun with -resume to finish")
+		}
+		return fmt.Errorf("interrupted; output is incomplete")
+	}
+	if truncated {
+		if cfg.checkpointFile != "" {
+			return fmt.Errorf("input stream truncated after %d records; rerun with -resume on the complete input to finish", seq)
+		}
+		return fmt.Errorf("input stream truncated after %d records; output is incomplete", seq)
+	}
+	if len(pending) > 0 {
+		seqs := []int64{}
+		for seq := range pending {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for _, seq := range seqs {
+			logger.Error("record produced no output", "record", seq+1, "chembl_id", pending[seq])
+		}
+		return fmt.Errorf("-strict: %d input records produced no output", len(pending))
+	}
+	return nil
+}
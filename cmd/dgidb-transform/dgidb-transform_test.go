@@ -0,0 +1,2841 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/biostream/dgidb-transform/dgidb"
+	"github.com/biostream/dgidb-transform/unichem"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// uniChemServer is a mock of the UniChem v1 compounds API. It maps each
+// ChEMBL ID in compounds to the IDs listed for it, keyed by src_id, and
+// counts the requests it gets, the lookups of every ID it is asked for and
+// the connections it accepts.
+type uniChemServer struct {
+	*httptest.Server
+	compounds map[string]map[int]string
+
+	mu      sync.Mutex
+	posts   int
+	lookups map[string]int
+	conns   int
+}
+
+func newUniChemServer(t *testing.T, compounds map[string]map[int]string) *uniChemServer {
+	s := &uniChemServer{compounds: compounds, lookups: map[string]int{}}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *uniChemServer) serve(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Compound string `json:"compound"`
+		SourceID int    `json:"sourceID"`
+	}{}
+	if r.URL.Path != "/api/v1/compounds" || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.posts++
+	s.lookups[req.Compound]++
+	s.mu.Unlock()
+
+	type source struct {
+		CompoundID string `json:"compoundId"`
+		ID         int    `json:"id"`
+	}
+	resp := struct {
+		Compounds []struct {
+			Sources []source `json:"sources"`
+		} `json:"compounds"`
+	}{}
+	if ids, ok := s.compounds[req.Compound]; ok {
+		sources := []source{{CompoundID: req.Compound, ID: 1}}
+		for src, id := range ids {
+			sources = append(sources, source{CompoundID: id, ID: src})
+		}
+		resp.Compounds = append(resp.Compounds, struct {
+			Sources []source `json:"sources"`
+		}{sources})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// requests returns the number of lookups of id.
+func (s *uniChemServer) requests(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups[id]
+}
+
+// requestCount returns the number of requests served.
+func (s *uniChemServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.posts
+}
+
+// total returns the number of lookups of every ID.
+func (s *uniChemServer) total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, lookups := range s.lookups {
+		n += lookups
+	}
+	return n
+}
+
+// connections returns the number of connections accepted so far.
+func (s *uniChemServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+// testCompounds maps aspirin and imatinib, the compounds most tests resolve.
+var testCompounds = map[string]map[int]string{
+	"CHEMBL25":  {2: "DB00945", 7: "15365", 22: "2244"},
+	"CHEMBL941": {2: "DB00619", 22: "5291"},
+}
+
+// testConfig returns the options of a run against srv that reads input,
+// writes to a file in a temporary directory and tries each request once,
+// without a rate limit.
+func testConfig(t *testing.T, srv *uniChemServer, input string) config {
+	cfg := defaultConfig()
+	cfg.inputFile = input
+	cfg.inputFormat = "ndjson"
+	cfg.outputFile = filepath.Join(t.TempDir(), "out.json")
+	cfg.unichemURL = srv.URL
+	cfg.retries = 0
+	cfg.backoff = time.Millisecond
+	cfg.rate = 0
+	cfg.sources, _ = unichem.ParseSources("")
+	return cfg
+}
+
+// writeInput writes lines to a file in a temporary directory and returns
+// its name.
+func writeInput(t *testing.T, lines ...string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "input.json")
+	err := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// record returns an input line for a Record with the given ChEMBL ID.
+func record(chemblID string) string {
+	return fmt.Sprintf(`{"id": "%s", "gene_name": "PTGS2", "drug_name": "ASPIRIN", "chembl_id": %q}`, strings.ToLower(chemblID), chemblID)
+}
+
+// discard is a logger for runs whose diagnostics are not checked.
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// runOutput runs cfg, which must succeed, and returns the lines written to
+// its output.
+func runOutput(t *testing.T, cfg config) []string {
+	t.Helper()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(cfg, discard); err != nil {
+		t.Fatal(err)
+	}
+	return readLines(t, cfg.outputFile)
+}
+
+// readLines returns the lines of the file name.
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+// decodeLines unmarshals each line into a JSON object.
+func decodeLines(t *testing.T, lines []string) []map[string]interface{} {
+	t.Helper()
+	objects := []map[string]interface{}{}
+	for i, line := range lines {
+		v := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("line %d: %v: %s", i+1, err, line)
+		}
+		objects = append(objects, v)
+	}
+	return objects
+}
+
+func TestRun(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25"), record("CHEMBL941")))
+	got := decodeLines(t, runOutput(t, cfg))
+
+	want := []map[string]interface{}{
+		{"chembl": "CHEMBL25", "drugbank": "DB00945", "chebi": "CHEBI:15365", "pubchem": "2244"},
+		{"chembl": "CHEMBL941", "drugbank": "DB00619", "pubchem": "5291"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if fmt.Sprint(got[i]) != fmt.Sprint(want[i]) {
+			t.Errorf("line %d = %v, want %v", i+1, got[i], want[i])
+		}
+	}
+	for _, id := range []string{"CHEMBL25", "CHEMBL941"} {
+		if n := srv.requests(id); n != 1 {
+			t.Errorf("%s looked up %d times, want 1", id, n)
+		}
+	}
+}
+
+func TestRunUnreachable(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+	srv.Close()
+
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 1 || got[0]["chembl"] != "CHEMBL25" || got[0]["error"] == nil {
+		t.Errorf("output = %v, want CHEMBL25 with an error", got)
+	}
+}
+
+func TestRunReusesConnections(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	lines := []string{}
+	for i := 0; i < 5; i++ {
+		lines = append(lines, record(fmt.Sprintf("CHEMBL%d", 100+i)))
+	}
+	cfg := testConfig(t, srv, writeInput(t, lines...))
+	if got := runOutput(t, cfg); len(got) != 5 {
+		t.Fatalf("got %d lines, want 5", len(got))
+	}
+	if n := srv.connections(); n != 1 {
+		t.Errorf("5 lookups opened %d connections, want 1", n)
+	}
+}
+
+func TestRateTicker(t *testing.T) {
+	tests := []struct {
+		rate    float64
+		limited bool
+	}{
+		{0, false},
+		{-1, false},
+		{math.NaN(), false},
+		{3, true},
+		{1e9, true},
+		// Finer than a ticker can pace.
+		{2e9, false},
+		{math.Inf(1), false},
+	}
+	for _, tt := range tests {
+		ticker := rateTicker(tt.rate)
+		if ticker != nil {
+			ticker.Stop()
+		}
+		if (ticker != nil) != tt.limited {
+			t.Errorf("rateTicker(%g) = %v, want a ticker: %v", tt.rate, ticker, tt.limited)
+		}
+	}
+}
+
+func TestRunRateLimit(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	lines := []string{}
+	for i := 0; i < 5; i++ {
+		lines = append(lines, record(fmt.Sprintf("CHEMBL%d", 100+i)))
+	}
+	cfg := testConfig(t, srv, writeInput(t, lines...))
+	cfg.threads = 5
+	cfg.rate = 20
+
+	start := time.Now()
+	runOutput(t, cfg)
+	// Five requests at 20 per second need at least four intervals of 50ms
+	// between them, however many threads send them.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 lookups at -rate 20 took %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestRunUnlimitedRate(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+	cfg.rate = 2e9
+	cfg.sourceRate = map[string]float64{"chembl": 2e9, "mygene": math.Inf(1)}
+	if got := runOutput(t, cfg); len(got) != 1 {
+		t.Errorf("got %d lines, want 1", len(got))
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// The second page of interactions waits for the lookup from the
+	// first, then scrapes the metrics.
+	var firstPage time.Duration
+	var metrics string
+	start := time.Now()
+	uc := newUniChemServer(t, testCompounds)
+	dgidbAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Variables struct {
+				After string `json:"after"`
+			} `json:"variables"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Variables.After == "" {
+			firstPage = time.Since(start)
+			fmt.Fprint(w, `{"data": {"interactions": {"pageInfo": {"endCursor": "1", "hasNextPage": true}, "nodes": [{"id": "1", "gene": {"name": "PTGS2"}, "drug": {"name": "ASPIRIN", "conceptId": "chembl:CHEMBL25"}}]}}}`)
+			return
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if uc.requestCount() == 0 {
+				continue
+			}
+			resp, err := http.Get("http://" + addr + "/metrics")
+			if err != nil {
+				continue
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			metrics = string(body)
+			if strings.Contains(metrics, `dgidb_transform_requests_total{service="unichem"} 1`) {
+				break
+			}
+		}
+		fmt.Fprint(w, `{"data": {"interactions": {"pageInfo": {"hasNextPage": false}, "nodes": []}}}`)
+	}))
+	defer dgidbAPI.Close()
+
+	cfg := testConfig(t, uc, "")
+	cfg.fromDGIdb = true
+	cfg.dgidbURL = dgidbAPI.URL
+	cfg.metricsAddr = addr
+	// One UniChem request a second must not hold back DGIdb.
+	cfg.rate = 1
+	if got := runOutput(t, cfg); len(got) != 1 {
+		t.Errorf("got %d lines, want 1", len(got))
+	}
+	if firstPage > 500*time.Millisecond {
+		t.Errorf("first DGIdb page was requested after %s, want it free of the UniChem rate", firstPage)
+	}
+	for _, want := range []string{
+		`dgidb_transform_requests_total{service="chembl"} 0`,
+		`dgidb_transform_requests_total{service="dgidb"} 2`,
+		`dgidb_transform_requests_total{service="mygene"} 0`,
+		`dgidb_transform_requests_total{service="unichem"} 1`,
+		`dgidb_transform_retries_total{service="unichem"} 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics =\n%s\nwant them to contain %s", metrics, want)
+		}
+	}
+}
+
+func TestRunLooksUpEachIDOnce(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL941"), record("CHEMBL25"), record("chembl25"), record("CHEMBL941"), record("CHEMBL25"))
+	cfg := testConfig(t, srv, input)
+	cfg.threads = 4
+	got := decodeLines(t, runOutput(t, cfg))
+
+	if len(got) != 6 {
+		t.Fatalf("got %d lines, want one per input record", len(got))
+	}
+	for _, id := range []string{"CHEMBL25", "CHEMBL941"} {
+		if n := srv.requests(id); n != 1 {
+			t.Errorf("%s looked up %d times, want 1", id, n)
+		}
+	}
+}
+
+func TestRunDiskCache(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL941"))
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	first := testConfig(t, srv, input)
+	first.cacheDir = cacheDir
+	want := runOutput(t, first)
+
+	second := testConfig(t, srv, input)
+	second.cacheDir = cacheDir
+	second.reportFile = filepath.Join(t.TempDir(), "report.json")
+	got := runOutput(t, second)
+
+	for _, id := range []string{"CHEMBL25", "CHEMBL941"} {
+		if n := srv.requests(id); n != 1 {
+			t.Errorf("%s looked up %d times over two runs, want 1", id, n)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("cached run wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	report := summary{}
+	body, err := os.ReadFile(second.reportFile)
+	if err == nil {
+		err = json.Unmarshal(body, &report)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.CacheHits != 2 {
+		t.Errorf("second run reported %d cache hits, want 2", report.CacheHits)
+	}
+
+	// Entries written under options that shape the compound differently
+	// are looked up again, then served to runs with the same options.
+	changes := []struct {
+		name string
+		set  func(*config)
+	}{
+		{"sources", func(cfg *config) { cfg.sources = map[string]bool{"2": true} }},
+		{"all-sources", func(cfg *config) { cfg.allSources = true }},
+		{"with-structure", func(cfg *config) { cfg.withStructure = true }},
+		{"keep-raw", func(cfg *config) { cfg.keepRaw = true }},
+	}
+	for i, tt := range changes {
+		for run := 0; run < 2; run++ {
+			cfg := testConfig(t, srv, input)
+			cfg.cacheDir = cacheDir
+			tt.set(&cfg)
+			runOutput(t, cfg)
+			if n, want := srv.requests("CHEMBL25"), i+2; n != want {
+				t.Errorf("-%s run %d: CHEMBL25 looked up %d times in all, want %d", tt.name, run+1, n, want)
+			}
+		}
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{name: "ndjson", input: record("CHEMBL25") + "\n" + record("CHEMBL941") + "\n", want: []string{"CHEMBL25", "CHEMBL941"}},
+		{name: "ndjson without final newline", input: record("CHEMBL25") + "\n" + record("CHEMBL941"), want: []string{"CHEMBL25", "CHEMBL941"}},
+		{name: "ndjson with blank lines", input: "\n" + record("CHEMBL25") + "\r\n\r\n  \n" + record("CHEMBL941") + "\n\n", want: []string{"CHEMBL25", "CHEMBL941"}},
+		{name: "array", input: "[" + record("CHEMBL25") + "," + record("CHEMBL941") + "]", want: []string{"CHEMBL25", "CHEMBL941"}},
+		{name: "indented array", input: "\n  [\n  " + record("CHEMBL25") + ",\n  " + record("CHEMBL941") + "\n]\n", want: []string{"CHEMBL25", "CHEMBL941"}},
+		{name: "empty array", input: "[]", want: []string{}},
+		{name: "empty", input: "", want: []string{}},
+		{name: "bad line", input: record("CHEMBL25") + "\n\n{oops\n", want: []string{"CHEMBL25"}, wantErr: "line 3:"},
+		{name: "truncated array", input: "[" + record("CHEMBL25") + ",", want: []string{"CHEMBL25"}, wantErr: "unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			err := readRecords(strings.NewReader(tt.input), 1024, nil, func(rec Record) error {
+				got = append(got, rec.ChemblID)
+				return nil
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRecordsSkipsBlankLines(t *testing.T) {
+	input := record("CHEMBL25") + "\n\n   \n" + record("CHEMBL941") + "\n"
+	bad := 0
+	n := 0
+	err := readRecords(strings.NewReader(input), 1024, func(line int, err error) error {
+		bad++
+		return nil
+	}, func(Record) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 2 || bad != 0 {
+		t.Errorf("read %d records and %d bad lines with error %v, want 2, 0 and none", n, bad, err)
+	}
+}
+
+// hugeRecord returns an input line for a Record with the given ChEMBL ID and
+// claims interaction claims, well past bufio.Scanner's default 64KB line
+// limit for a few thousand of them.
+func hugeRecord(chemblID string, claims int) string {
+	rec := Record{ID: strings.ToLower(chemblID), DrugName: "ASPIRIN", ChemblID: chemblID}
+	for i := 0; i < claims; i++ {
+		rec.InteractionClaims = append(rec.InteractionClaims, InteractionClaim{
+			Source:           fmt.Sprintf("Source%d", i),
+			Drug:             "ASPIRIN",
+			Gene:             "PTGS2",
+			InteractionTypes: []string{"inhibitor"},
+		})
+	}
+	line, _ := json.Marshal(rec)
+	return string(line)
+}
+
+func TestReadRecordsHugeLine(t *testing.T) {
+	huge := hugeRecord("CHEMBL25", 5000)
+	if len(huge) < 256*1024 {
+		t.Fatalf("test record is only %d bytes", len(huge))
+	}
+	input := huge + "\n" + record("CHEMBL941") + "\n"
+
+	claims := []int{}
+	err := readRecords(strings.NewReader(input), defaultConfig().maxLine, nil, func(rec Record) error {
+		claims = append(claims, len(rec.InteractionClaims))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims) != 2 || claims[0] != 5000 {
+		t.Errorf("read records with %v claims, want [5000 0]", claims)
+	}
+
+	err = readRecords(strings.NewReader(input), 64*1024, nil, func(Record) error { return nil })
+	if !errors.Is(err, bufio.ErrTooLong) || !strings.Contains(err.Error(), "longer than 65536 bytes") {
+		t.Errorf("error = %v, want a record longer than 65536 bytes", err)
+	}
+}
+
+func TestRunHugeLine(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, hugeRecord("CHEMBL25", 5000), record("CHEMBL941")))
+	objects := decodeLines(t, runOutput(t, cfg))
+	if len(objects) != 2 || objects[0]["pubchem"] != "2244" || objects[1]["pubchem"] != "5291" {
+		t.Errorf("output = %v, want CHEMBL25 and CHEMBL941 resolved", objects)
+	}
+}
+
+func TestRunModes(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"))
+	compound := map[string]interface{}{"chembl": "CHEMBL25", "drugbank": "DB00945", "chebi": "CHEBI:15365", "pubchem": "2244"}
+	tests := []struct {
+		mode string
+		want map[string]interface{}
+	}{
+		{mode: "ids", want: compound},
+		{mode: "enrich", want: map[string]interface{}{
+			"id":        "chembl25",
+			"gene_name": "PTGS2",
+			"drug_name": "ASPIRIN",
+			"chembl_id": "CHEMBL25",
+			"compound":  compound,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.mode = tt.mode
+			got := decodeLines(t, runOutput(t, cfg))
+			if len(got) != 1 || fmt.Sprint(got[0]) != fmt.Sprint(tt.want) {
+				t.Errorf("output = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDelimitedOutput(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL941"))
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{format: "tsv", want: []string{"chembl\tdrugbank\tpubchem\terror", "CHEMBL25\tDB00945\t2244\t", "CHEMBL941\tDB00619\t5291\t"}},
+		{format: "csv", want: []string{"chembl,drugbank,pubchem,error", "CHEMBL25,DB00945,2244,", "CHEMBL941,DB00619,5291,"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.outputFormat = tt.format
+			cfg.sources = map[string]bool{"2": true, "22": true}
+			if got := runOutput(t, cfg); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVWriterQuoting(t *testing.T) {
+	rec := EnrichedRecord{Compound: unichem.CompoundID{ChEMBL: "CHEMBL25", DrugBank: "DB00945,DB01234", PubChem: "22\t44", Error: `say "no"`}}
+	tests := []struct {
+		comma rune
+		want  string
+	}{
+		{',', "chembl,drugbank,pubchem,error\nCHEMBL25,\"DB00945,DB01234\",22\t44,\"say \"\"no\"\"\"\n"},
+		{'\t', "chembl\tdrugbank\tpubchem\terror\nCHEMBL25\tDB00945,DB01234\t\"22\t44\"\t\"say \"\"no\"\"\"\n"},
+	}
+	for _, tt := range tests {
+		out := &strings.Builder{}
+		w := newCSVWriter(out, tt.comma, map[string]bool{"2": true, "22": true})
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q output = %q, want %q", tt.comma, out.String(), tt.want)
+		}
+	}
+}
+
+// gunzipLines returns the lines of the gzipped file name.
+func gunzipLines(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+}
+
+func TestRunGzip(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+
+	// The input is detected from its content, whatever its name.
+	input := filepath.Join(t.TempDir(), "input.json")
+	f, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	fmt.Fprintln(zw, record("CHEMBL25"))
+	fmt.Fprintln(zw, record("CHEMBL941"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	tests := []struct {
+		name   string
+		output string
+		force  bool
+	}{
+		{name: "extension", output: "out.json.gz"},
+		{name: "forced", output: "out.json", force: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.outputFile = filepath.Join(t.TempDir(), tt.output)
+			cfg.forceGzip = tt.force
+			if err := cfg.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := run(cfg, discard); err != nil {
+				t.Fatal(err)
+			}
+			got := decodeLines(t, gunzipLines(t, cfg.outputFile))
+			if len(got) != 2 || got[0]["pubchem"] != "2244" || got[1]["pubchem"] != "5291" {
+				t.Errorf("output = %v, want CHEMBL25 and CHEMBL941 resolved", got)
+			}
+		})
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	go func() {
+		fmt.Fprintln(w, record("CHEMBL25"))
+		fmt.Fprintln(w, record("CHEMBL941"))
+		w.Close()
+	}()
+
+	got := decodeLines(t, runOutput(t, testConfig(t, srv, "")))
+	if len(got) != 2 || got[0]["pubchem"] != "2244" || got[1]["pubchem"] != "5291" {
+		t.Errorf("output = %v, want CHEMBL25 and CHEMBL941 resolved", got)
+	}
+}
+
+func TestRunInChIKeyAttribute(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[{"src_id": "1", "src_compound_id": "CHEMBL25"}, {"src_id": "22", "src_compound_id": "2244"}]`)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t, &uniChemServer{Server: srv}, writeInput(t,
+		`{"id": "x", "drug_name": "ASPIRIN", "attributes": [{"name": "InChIKey", "value": "BSYNRYMUTXBXSQ-UHFFFAOYSA-N"}]}`))
+	got := decodeLines(t, runOutput(t, cfg))
+	if path != "/rest/inchikey/BSYNRYMUTXBXSQ-UHFFFAOYSA-N" {
+		t.Errorf("looked up %q, want the InChIKey", path)
+	}
+	if len(got) != 1 || got[0]["chembl"] != "CHEMBL25" || got[0]["pubchem"] != "2244" {
+		t.Errorf("output = %v, want CHEMBL25 resolved from its InChIKey", got)
+	}
+}
+
+// readReport decodes the -report file name.
+func readReport(t *testing.T, name string) summary {
+	t.Helper()
+	body, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := summary{}
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	return report
+}
+
+func TestRunMissingChEMBLID(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, `{"id": "x", "gene_name": "PTGS2", "drug_name": "ASPIRIN"}`, `{"id": "y", "chembl_id": ""}`)
+	tests := []struct {
+		name           string
+		skipUnresolved bool
+		want           []string
+	}{
+		{name: "emitted", want: []string{`{"error":"record has no ChEMBL ID"}`, `{"error":"record has no ChEMBL ID"}`}},
+		{name: "skipped", skipUnresolved: true, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.skipUnresolved = tt.skipUnresolved
+			cfg.reportFile = filepath.Join(t.TempDir(), "report.json")
+			if got := runOutput(t, cfg); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if n := srv.total(); n != 0 {
+				t.Errorf("sent %d lookups, want none", n)
+			}
+			if report := readReport(t, cfg.reportFile); report.Records != 2 || report.Unresolved != 2 {
+				t.Errorf("report counts %d records and %d unresolved, want 2 and 2", report.Records, report.Unresolved)
+			}
+		})
+	}
+}
+
+func TestInteractionClaimJSONRoundTrip(t *testing.T) {
+	const line = `{"source":"DrugBank","drug":"ASPIRIN","gene":"PTGS2","interaction_types":["inhibitor","antagonist"]}`
+	claim := InteractionClaim{}
+	if err := json.Unmarshal([]byte(line), &claim); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(claim.InteractionTypes, ",") != "inhibitor,antagonist" {
+		t.Errorf("InteractionTypes = %q", claim.InteractionTypes)
+	}
+	body, err := json.Marshal(claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != line {
+		t.Errorf("round trip = %s, want %s", body, line)
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	ids := []string{"CHEMBL25", "CHEMBL941", "CHEMBL3", "CHEMBL4"}
+	lines := []string{}
+	for _, id := range ids {
+		lines = append(lines, record(id))
+	}
+	cfg := testConfig(t, srv, writeInput(t, lines...))
+	cfg.checkpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	cfg.resume = true
+
+	// The first run stops after two records, as if it had been killed there.
+	first := cfg
+	first.limit = 2
+	if got := runOutput(t, first); len(got) != 2 {
+		t.Fatalf("first run wrote %d lines, want 2", len(got))
+	}
+	cp, err := loadCheckpoint(cfg.checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Next != 2 {
+		t.Errorf("checkpoint next = %d, want 2", cp.Next)
+	}
+	// A crash can leave output written after the last checkpoint.
+	f, err := os.OpenFile(cfg.outputFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(f, `{"chembl":"CHEMBL3","pub`)
+	f.Close()
+
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != len(ids) {
+		t.Fatalf("resumed output has %d lines, want %d: %v", len(got), len(ids), got)
+	}
+	for i, id := range ids {
+		if got[i]["chembl"] != id {
+			t.Errorf("line %d = %v, want %s", i+1, got[i], id)
+		}
+		if n := srv.requests(id); n != 1 {
+			t.Errorf("%s looked up %d times, want 1", id, n)
+		}
+	}
+	if cp, _ := loadCheckpoint(cfg.checkpointFile); cp.Next != int64(len(ids)) {
+		t.Errorf("final checkpoint next = %d, want %d", cp.Next, len(ids))
+	}
+}
+
+// TestMainProcess runs main with the arguments after "--" when the test
+// binary is re-executed by runMain.
+func TestMainProcess(t *testing.T) {
+	if os.Getenv("DGIDB_TRANSFORM_MAIN") != "1" {
+		t.Skip("only run by runMain")
+	}
+	for i, arg := range os.Args {
+		if arg == "--" {
+			os.Args = append([]string{"dgidb-transform"}, os.Args[i+1:]...)
+			break
+		}
+	}
+	main()
+	os.Exit(0)
+}
+
+// runMain runs main in a new process with args and returns what it wrote to
+// stderr and its exit code.
+func runMain(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "DGIDB_TRANSFORM_MAIN=1")
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	exit := &exec.ExitError{}
+	if errors.As(err, &exit) {
+		return stderr.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stderr.String(), 0
+}
+
+func TestMainFailedOpen(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	stderr, code := runMain(t, "-input", missing, "-output", filepath.Join(t.TempDir(), "out.json"), "-log-format", "json")
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if strings.Contains(stderr, "panic") || strings.Contains(stderr, "goroutine") {
+		t.Errorf("main panicked:\n%s", stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("last diagnostic is not JSON: %v\n%s", err, stderr)
+	}
+	msg, _ := entry["msg"].(string)
+	if entry["level"] != "ERROR" || !strings.Contains(msg, "missing.json") {
+		t.Errorf("last diagnostic = %v, want an ERROR naming the missing file", entry)
+	}
+}
+
+func TestRunCorruptLine(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"), `{"id": "broken", "chembl_id": "CHEMBL`, record("CHEMBL941"))
+
+	t.Run("skipped", func(t *testing.T) {
+		cfg := testConfig(t, srv, input)
+		cfg.skipBadLines = true
+		cfg.reportFile = filepath.Join(t.TempDir(), "report.json")
+		logs := &strings.Builder{}
+		if err := run(cfg, slog.New(slog.NewTextHandler(logs, nil))); err != nil {
+			t.Fatal(err)
+		}
+		got := decodeLines(t, readLines(t, cfg.outputFile))
+		if len(got) != 2 || got[0]["chembl"] != "CHEMBL25" || got[1]["chembl"] != "CHEMBL941" {
+			t.Errorf("output = %v, want the two good records", got)
+		}
+		if report := readReport(t, cfg.reportFile); report.BadLines != 1 {
+			t.Errorf("report counts %d bad lines, want 1", report.BadLines)
+		}
+		if !strings.Contains(logs.String(), `level=WARN msg="skipping bad input line" line=2`) {
+			t.Errorf("bad line not logged:\n%s", logs)
+		}
+	})
+
+	t.Run("fatal", func(t *testing.T) {
+		err := run(testConfig(t, srv, input), discard)
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("error = %v, want one naming line 2", err)
+		}
+		_, code := runMain(t, "-input", input, "-output", filepath.Join(t.TempDir(), "out.json"), "-unichem-url", srv.URL, "-rate", "0")
+		if code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	})
+}
+
+// fill sets every exported field reachable from v, which must be settable,
+// to a non-zero value.
+func fill(v reflect.Value) {
+	if v.Type() == reflect.TypeOf(json.RawMessage{}) {
+		v.SetBytes([]byte(`{"compounds":[{"standardInchiKey":"BSYNRYMUTXBXSQ-UHFFFAOYSA-N"}]}`))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Int64:
+		// Beyond the integers a float64 holds exactly.
+		v.SetInt(1<<53 + 1)
+	case reflect.Float64:
+		v.SetFloat(180.16)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fill(elem)
+		v.SetMapIndex(reflect.ValueOf("k"), elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i))
+			}
+		}
+	}
+}
+
+// unsetFields returns the fields of msg and the messages within it that
+// are not set.
+func unsetFields(msg protoreflect.Message) []string {
+	unset := []string{}
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !msg.Has(fd) {
+			unset = append(unset, string(fd.FullName()))
+			continue
+		}
+		if fd.Message() == nil || fd.IsMap() || fd.Message().FullName() == "google.protobuf.Value" {
+			continue
+		}
+		if fd.IsList() {
+			unset = append(unset, unsetFields(msg.Get(fd).List().Get(0).Message())...)
+		} else {
+			unset = append(unset, unsetFields(msg.Get(fd).Message())...)
+		}
+	}
+	return unset
+}
+
+// TestJSONPBWriterMatchesProto checks that every field of the written
+// structs has a counterpart in dgidb.proto and the other way round, so the
+// two cannot drift apart.
+func TestJSONPBWriterMatchesProto(t *testing.T) {
+	rec := EnrichedRecord{}
+	fill(reflect.ValueOf(&rec).Elem())
+	tests := []struct {
+		mode string
+		msg  proto.Message
+	}{
+		{mode: "ids", msg: &dgidb.CompoundID{}},
+		{mode: "enrich", msg: &dgidb.Record{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			w := &jsonpbWriter{w: out, enrich: tt.mode == "enrich"}
+			if err := w.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Count(out.Bytes(), []byte("\n")) != 1 {
+				t.Errorf("output is not a single line: %s", out)
+			}
+			if err := protojson.Unmarshal(out.Bytes(), tt.msg); err != nil {
+				t.Fatal(err)
+			}
+			if unset := unsetFields(tt.msg.ProtoReflect()); len(unset) > 0 {
+				t.Errorf("proto fields with no struct field: %v", unset)
+			}
+
+			// The output round-trips through the generated messages.
+			again, err := protojson.Marshal(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want, got interface{}
+			json.Unmarshal(out.Bytes(), &want)
+			json.Unmarshal(again, &got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip = %s, want %s", again, out)
+			}
+		})
+	}
+}
+
+func TestJSONPBWriter(t *testing.T) {
+	rec := EnrichedRecord{
+		Record: Record{ID: "x", GeneName: "PTGS2", EntrezID: 5743, Publications: []int64{12345}},
+		Compound: unichem.CompoundID{
+			ChEMBL:     "CHEMBL25",
+			AllSources: map[string]string{"drugbank": "DB00945"},
+			IDs:        map[string][]string{"kegg": {"C01405", "D00109"}},
+			FullMWT:    180.16,
+			Raw:        json.RawMessage(`{"compounds": []}`),
+		},
+	}
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: "ids", want: `{"chembl":"CHEMBL25","allSources":{"drugbank":"DB00945"},"ids":{"kegg":{"ids":["C01405","D00109"]}},"fullMwt":180.16,"_raw":{"compounds":[]}}`},
+		{mode: "enrich", want: `{"id":"x","geneName":"PTGS2","entrezId":"5743","publications":["12345"],"compound":{"chembl":"CHEMBL25","allSources":{"drugbank":"DB00945"},"ids":{"kegg":{"ids":["C01405","D00109"]}},"fullMwt":180.16,"_raw":{"compounds":[]}}}`},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		w := &jsonpbWriter{w: out, enrich: tt.mode == "enrich"}
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(out.String(), "\n"); got != tt.want {
+			t.Errorf("%s output =\n%s\nwant\n%s", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25"), record("chembl25"), record("CHEMBL941"), `{"id": "x"}`, `{oops`))
+	cfg.dryRun = true
+	cfg.skipBadLines = true
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	logs := &strings.Builder{}
+	if err := dryRun(cfg, slog.New(slog.NewTextHandler(logs, nil))); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.total(); n != 0 {
+		t.Errorf("dry run sent %d lookups, want none", n)
+	}
+	if _, err := os.Stat(cfg.outputFile); !os.IsNotExist(err) {
+		t.Errorf("dry run created its output: %v", err)
+	}
+	if want := "records=4 unique_ids=2 unresolved=1 invalid_ids=0 bad_lines=1"; !strings.Contains(logs.String(), want) {
+		t.Errorf("dry run logged\n%s\nwant it to report %s", logs, want)
+	}
+}
+
+func TestRunProxyAndUserAgent(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	var mu sync.Mutex
+	hosts, agents := []string{}, []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		agents = append(agents, r.Header.Get("User-Agent"))
+		mu.Unlock()
+		upstream.serve(w, r)
+	}))
+	defer proxy.Close()
+
+	cfg := testConfig(t, upstream, writeInput(t, record("CHEMBL25")))
+	// Only the proxy can reach this host.
+	cfg.unichemURL = "http://unichem.invalid"
+	cfg.proxy = proxy.URL
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 1 || got[0]["pubchem"] != "2244" {
+		t.Errorf("output = %v, want CHEMBL25 resolved through the proxy", got)
+	}
+	if len(hosts) != 1 || hosts[0] != "unichem.invalid" {
+		t.Errorf("proxy saw requests for %q, want one for unichem.invalid", hosts)
+	}
+	for _, agent := range agents {
+		if !strings.HasPrefix(agent, "dgidb-transform/") {
+			t.Errorf("User-Agent = %q, want dgidb-transform/<version>", agent)
+		}
+	}
+}
+
+func TestRunDedup(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	other := `{"id": "other", "gene_name": "PTGS1", "drug_name": "ASPIRIN", "chembl_id": "CHEMBL25"}`
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL25"), other, record("CHEMBL941"))
+	tests := []struct {
+		mode string
+		// want lists the chembl_id, or in enrich mode the id, of each line.
+		want []string
+	}{
+		{mode: "ids", want: []string{"CHEMBL25", "CHEMBL941"}},
+		// The second interaction of CHEMBL25 is a different record.
+		{mode: "enrich", want: []string{"chembl25", "other", "chembl941"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.mode = tt.mode
+			cfg.dedup = true
+			cfg.ordered = true
+			got := []string{}
+			for _, v := range decodeLines(t, runOutput(t, cfg)) {
+				if tt.mode == "enrich" {
+					got = append(got, fmt.Sprint(v["id"]))
+				} else {
+					got = append(got, fmt.Sprint(v["chembl"]))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		values []string
+		want   bool
+	}{
+		{filter: "", values: []string{"DrugBank"}, want: true},
+		{filter: "", values: nil, want: true},
+		{filter: "drugbank", values: []string{"DrugBank"}, want: true},
+		{filter: " DrugBank , ChEMBL ", values: []string{"TTD", "chembl"}, want: true},
+		{filter: "DrugBank", values: []string{"TTD", "GuideToPharmacology"}, want: false},
+		{filter: "DrugBank", values: nil, want: false},
+		{filter: "inhibitor,antagonist", values: []string{"Inhibitor"}, want: true},
+		{filter: "inhibitor", values: []string{"inhibitor allosteric"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := matchesFilter(parseFilter(tt.filter), tt.values); got != tt.want {
+			t.Errorf("filter %q matches %q = %v, want %v", tt.filter, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestRunFilters(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t,
+		`{"id": "a", "chembl_id": "CHEMBL25", "sources": ["DrugBank"], "interaction_types": ["inhibitor"]}`,
+		`{"id": "b", "chembl_id": "CHEMBL941", "sources": ["TTD"], "interaction_types": ["inhibitor"]}`,
+		`{"id": "c", "chembl_id": "CHEMBL3", "sources": ["DrugBank"], "interaction_types": ["agonist"]}`,
+		`{"id": "d", "chembl_id": "CHEMBL4"}`,
+	)
+	tests := []struct {
+		name   string
+		source string
+		types  string
+		want   []string
+	}{
+		{name: "none", want: []string{"a", "b", "c", "d"}},
+		{name: "source", source: "drugbank", want: []string{"a", "c"}},
+		{name: "type", types: "INHIBITOR", want: []string{"a", "b"}},
+		{name: "both", source: "DrugBank", types: "inhibitor", want: []string{"a"}},
+		{name: "several", source: "ttd, drugbank", types: "agonist,inhibitor", want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.mode = "enrich"
+			cfg.ordered = true
+			cfg.filterSource = parseFilter(tt.source)
+			cfg.filterType = parseFilter(tt.types)
+			cfg.reportFile = filepath.Join(t.TempDir(), "report.json")
+			got := []string{}
+			for _, v := range decodeLines(t, runOutput(t, cfg)) {
+				got = append(got, fmt.Sprint(v["id"]))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if report := readReport(t, cfg.reportFile); report.Filtered != int64(4-len(tt.want)) {
+				t.Errorf("report counts %d filtered, want %d", report.Filtered, 4-len(tt.want))
+			}
+		})
+	}
+
+	// Filtered records are never looked up.
+	for id, want := range map[string]int{"CHEMBL25": 5, "CHEMBL941": 3, "CHEMBL3": 3, "CHEMBL4": 1} {
+		if n := srv.requests(id); n != want {
+			t.Errorf("%s looked up %d times, want %d", id, n, want)
+		}
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL25"), record("CHEMBL941"), record("CHEMBL3"), record("CHEMBL4"), record("CHEMBL5"))
+	tests := []struct {
+		name  string
+		dedup bool
+		want  int
+	}{
+		{name: "plain", want: 4},
+		// The limit counts input records, not output lines.
+		{name: "dedup", dedup: true, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, srv, input)
+			cfg.limit = 4
+			cfg.threads = 3
+			cfg.dedup = tt.dedup
+			if got := runOutput(t, cfg); len(got) != tt.want {
+				t.Errorf("wrote %d lines, want %d: %q", len(got), tt.want, got)
+			}
+		})
+	}
+	for _, id := range []string{"CHEMBL4", "CHEMBL5"} {
+		if n := srv.requests(id); n != 0 {
+			t.Errorf("%s, past the limit, looked up %d times", id, n)
+		}
+	}
+}
+
+func TestRunInterrupt(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	arrived := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		// The interrupt comes while CHEMBL941 is being looked up, which
+		// must still be written.
+		if bytes.Contains(body, []byte("CHEMBL941")) {
+			close(arrived)
+			time.Sleep(100 * time.Millisecond)
+		}
+		upstream.serve(w, r)
+	}))
+	defer srv.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	fmt.Fprintln(w, record("CHEMBL25"))
+	fmt.Fprintln(w, record("CHEMBL941"))
+
+	cfg := testConfig(t, &uniChemServer{Server: srv}, "")
+	cfg.checkpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- run(cfg, discard) }()
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CHEMBL941 was never looked up")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	// A record read after the interrupt is not started.
+	time.Sleep(20 * time.Millisecond)
+	fmt.Fprintln(w, record("CHEMBL3"))
+
+	err = <-errc
+	if err == nil || !strings.Contains(err.Error(), "interrupted; rerun with -resume") {
+		t.Errorf("error = %v, want an interruption", err)
+	}
+	got := decodeLines(t, readLines(t, cfg.outputFile))
+	if len(got) != 2 || got[0]["chembl"] != "CHEMBL25" || got[1]["pubchem"] != "5291" {
+		t.Errorf("output = %v, want CHEMBL25 and CHEMBL941 complete", got)
+	}
+	if n := upstream.requests("CHEMBL3"); n != 0 {
+		t.Errorf("CHEMBL3 looked up %d times after the interrupt", n)
+	}
+	if cp, err := loadCheckpoint(cfg.checkpointFile); err != nil || cp.Next != 2 {
+		t.Errorf("checkpoint = %+v, %v; want the two written records", cp, err)
+	}
+}
+
+func TestRunIDList(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, "CHEMBL25", "", "  chembl941  ", "\t"))
+	cfg.inputFormat = "idlist"
+	got := decodeLines(t, runOutput(t, cfg))
+
+	want := []map[string]interface{}{
+		{"chembl": "CHEMBL25", "drugbank": "DB00945", "chebi": "CHEBI:15365", "pubchem": "2244"},
+		{"chembl": "CHEMBL941", "drugbank": "DB00619", "pubchem": "5291"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if fmt.Sprint(got[i]) != fmt.Sprint(want[i]) {
+			t.Errorf("line %d = %v, want %v", i+1, got[i], want[i])
+		}
+	}
+}
+
+// keys returns the sorted keys of m.
+func keys(m map[string]interface{}) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRunFlat(t *testing.T) {
+	tests := []struct {
+		mode string
+		flat bool
+		want []string
+	}{
+		{"ids", false, []string{"chebi", "chembl", "drugbank", "pubchem"}},
+		{"ids", true, []string{"chebi_id", "chembl_id", "drugbank_id", "pubchem_id"}},
+		{"enrich", false, []string{"chembl_id", "compound", "drug_name", "gene_name", "id"}},
+		{"enrich", true, []string{"chebi_id", "chembl_id", "drug_name", "drugbank_id", "gene_name", "id", "pubchem_id"}},
+	}
+	srv := newUniChemServer(t, testCompounds)
+	for _, tt := range tests {
+		cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+		cfg.mode = tt.mode
+		cfg.flat = tt.flat
+		got := decodeLines(t, runOutput(t, cfg))
+		if len(got) != 1 {
+			t.Fatalf("-mode %s -flat=%t: got %d lines, want 1", tt.mode, tt.flat, len(got))
+		}
+		if fmt.Sprint(keys(got[0])) != fmt.Sprint(tt.want) {
+			t.Errorf("-mode %s -flat=%t keys = %v, want %v", tt.mode, tt.flat, keys(got[0]), tt.want)
+		}
+		if tt.flat && (got[0]["chembl_id"] != "CHEMBL25" || got[0]["pubchem_id"] != "2244") {
+			t.Errorf("-mode %s -flat output = %v, want CHEMBL25 and PubChem 2244", tt.mode, got[0])
+		}
+	}
+}
+
+func TestRunManifest(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	for _, where := range []string{"inline", "sidecar"} {
+		t.Run(where, func(t *testing.T) {
+			input := writeInput(t, record("CHEMBL25"), record("CHEMBL941"))
+			cfg := testConfig(t, srv, input)
+			cfg.sources, _ = unichem.ParseSources("2,22")
+			cfg.manifest = where
+			start := time.Now().UTC().Truncate(time.Second)
+			lines := runOutput(t, cfg)
+
+			var body []byte
+			if where == "inline" {
+				if len(lines) != 3 {
+					t.Fatalf("got %d lines, want the manifest and 2 records", len(lines))
+				}
+				wrapped := map[string]json.RawMessage{}
+				if err := json.Unmarshal([]byte(lines[0]), &wrapped); err != nil || len(wrapped) != 1 {
+					t.Fatalf("first line = %s, want a lone _manifest object", lines[0])
+				}
+				body = wrapped["_manifest"]
+			} else {
+				if len(lines) != 2 {
+					t.Fatalf("got %d lines, want 2 records", len(lines))
+				}
+				var err error
+				body, err = os.ReadFile(cfg.outputFile + ".manifest.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := manifest{}
+			if err := json.Unmarshal(body, &m); err != nil {
+				t.Fatalf("manifest %s: %v", body, err)
+			}
+			if m.Created.Before(start) || m.Created.After(time.Now()) {
+				t.Errorf("manifest created %s, want the time of the run", m.Created)
+			}
+			m.Created = time.Time{}
+			want := manifest{
+				Tool:         "dgidb-transform",
+				Version:      versionString(),
+				Input:        input,
+				InputSource:  "1",
+				UniChemURL:   srv.URL,
+				API:          cfg.api,
+				Mode:         "ids",
+				OutputFormat: "json",
+				Sources:      map[string]string{"2": "drugbank", "22": "pubchem"},
+			}
+			if !reflect.DeepEqual(m, want) {
+				t.Errorf("manifest = %+v, want %+v", m, want)
+			}
+		})
+	}
+}
+
+// failingServer passes requests on to upstream, except those naming
+// chemblID, which fail with a 500.
+func failingServer(t *testing.T, upstream *uniChemServer, chemblID string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte(chemblID)) {
+			http.Error(w, "oops", http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		upstream.serve(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunKeepFailed(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	srv := failingServer(t, upstream, "CHEMBL941")
+
+	unresolved := `{"id": "x", "gene_name": "PTGS2", "drug_name": "MYSTERY"}`
+	cfg := testConfig(t, upstream, writeInput(t, record("CHEMBL25"), record("CHEMBL941"), unresolved))
+	cfg.unichemURL = srv.URL
+	cfg.keepFailed = true
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 3 {
+		t.Fatalf("got %d lines, want every record: %v", len(got), got)
+	}
+	byID := map[string]map[string]interface{}{}
+	for _, line := range got {
+		id, _ := line["id"].(string)
+		if id == "" {
+			id, _ = line["chembl"].(string)
+		}
+		byID[id] = line
+	}
+
+	if c := byID["CHEMBL25"]; c == nil || c["pubchem"] != "2244" || c["error"] != nil {
+		t.Errorf("CHEMBL25 = %v, want its resolved CompoundID", c)
+	}
+	failed := byID["chembl941"]
+	if failed == nil {
+		t.Fatalf("output = %v, want the failed record", got)
+	}
+	if msg, _ := failed["error"].(string); !strings.Contains(msg, "500") {
+		t.Errorf("failed record error = %v, want the 500", failed["error"])
+	}
+	delete(failed, "error")
+	original := map[string]interface{}{}
+	json.Unmarshal([]byte(record("CHEMBL941")), &original)
+	if !reflect.DeepEqual(failed, original) {
+		t.Errorf("failed record = %v, want the input %v", failed, original)
+	}
+	if x := byID["x"]; x == nil || x["drug_name"] != "MYSTERY" || x["error"] == nil {
+		t.Errorf("record without an ID = %v, want it kept with an error", x)
+	}
+}
+
+// chemblServer serves the ChEMBL molecule records in molecules, keyed by
+// ChEMBL ID, and 404 for any other.
+func chemblServer(t *testing.T, molecules map[string]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := molecules[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/molecule/"), ".json")]
+		if !ok {
+			http.Error(w, `{"error_message": "not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// recordedMolecule returns the recorded ChEMBL molecule in testdata/name.
+func recordedMolecule(t *testing.T, name string) string {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestChEMBLMoleculeATC(t *testing.T) {
+	srv := chemblServer(t, map[string]string{
+		"CHEMBL25":    recordedMolecule(t, "chembl_CHEMBL25.json"),
+		"CHEMBL1201":  `{"molecule_chembl_id": "CHEMBL1201", "atc_classifications": ["L04AA10"]}`,
+		"CHEMBL10000": `{"molecule_chembl_id": "CHEMBL10000", "atc_classifications": []}`,
+	})
+	chembl := &ChEMBL{Fetcher: &unichem.Fetcher{HTTPClient: srv.Client()}, BaseURL: srv.URL}
+	tests := []struct {
+		chemblID string
+		want     []string
+	}{
+		{"CHEMBL25", []string{"A01AD05", "B01AC06", "N02BA01"}},
+		{"CHEMBL1201", []string{"L04AA10"}},
+		{"CHEMBL10000", []string{}},
+	}
+	for _, tt := range tests {
+		mol, err := chembl.Molecule(context.Background(), tt.chemblID)
+		if err != nil {
+			t.Errorf("%s: %v", tt.chemblID, err)
+			continue
+		}
+		if fmt.Sprint(mol.ATCClassifications) != fmt.Sprint(tt.want) {
+			t.Errorf("%s ATC = %v, want %v", tt.chemblID, mol.ATCClassifications, tt.want)
+		}
+	}
+	if _, err := chembl.Molecule(context.Background(), "CHEMBL404"); !errors.Is(err, unichem.ErrNotFound) {
+		t.Errorf("missing molecule error = %v, want ErrNotFound", err)
+	}
+
+	// A compound without ATC codes leaves the field out.
+	body, err := json.Marshal(unichem.CompoundID{ChEMBL: "CHEMBL10000"})
+	if err != nil || strings.Contains(string(body), "atc") {
+		t.Errorf("CompoundID without ATC codes = %s, %v", body, err)
+	}
+}
+
+func TestChEMBLMoleculeProperties(t *testing.T) {
+	srv := chemblServer(t, map[string]string{
+		"CHEMBL25": recordedMolecule(t, "chembl_CHEMBL25.json"),
+		// Biotherapeutics have no computed properties.
+		"CHEMBL1201": `{"molecule_chembl_id": "CHEMBL1201", "molecule_properties": null}`,
+	})
+	chembl := &ChEMBL{Fetcher: &unichem.Fetcher{HTTPClient: srv.Client()}, BaseURL: srv.URL}
+
+	mol, err := chembl.Molecule(context.Background(), "CHEMBL25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mol.Properties == nil || mol.Properties.MolecularFormula != "C9H8O4" || mol.Properties.FullMWT != "180.16" {
+		t.Fatalf("CHEMBL25 properties = %+v, want C9H8O4 of 180.16", mol.Properties)
+	}
+	mwt, err := mol.Properties.FullMWT.Float64()
+	if err != nil || mwt != 180.16 {
+		t.Errorf("full_mwt = %v, %v; want 180.16", mwt, err)
+	}
+	body, err := json.Marshal(unichem.CompoundID{ChEMBL: "CHEMBL25", FullMWT: mwt, MolecularFormula: mol.Properties.MolecularFormula})
+	if err != nil || !strings.Contains(string(body), `"full_mwt":180.16,"molecular_formula":"C9H8O4"`) {
+		t.Errorf("CompoundID = %s, %v; want a numeric full_mwt", body, err)
+	}
+
+	mol, err = chembl.Molecule(context.Background(), "CHEMBL1201")
+	if err != nil || mol.Properties != nil {
+		t.Errorf("CHEMBL1201 properties = %+v, %v; want none", mol.Properties, err)
+	}
+}
+
+func TestMainQuiet(t *testing.T) {
+	srv := failingServer(t, newUniChemServer(t, testCompounds), "CHEMBL941")
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL941"))
+	for _, quiet := range []bool{false, true} {
+		dir := t.TempDir()
+		output, report := filepath.Join(dir, "out.json"), filepath.Join(dir, "report.json")
+		stderr, code := runMain(t, "-input", input, "-output", output, "-unichem-url", srv.URL,
+			"-retries", "0", "-rate", "0", "-report", report, fmt.Sprintf("-quiet=%t", quiet))
+		if code != 0 {
+			t.Fatalf("-quiet=%t: exit code = %d, want 0:\n%s", quiet, code, stderr)
+		}
+		if quiet && stderr != "" {
+			t.Errorf("-quiet wrote to stderr:\n%s", stderr)
+		}
+		if !quiet && !strings.Contains(stderr, "CHEMBL941") {
+			t.Errorf("stderr without -quiet =\n%s\nwant the failed lookup", stderr)
+		}
+		if got := readReport(t, report); got.Records != 2 || got.Failed != 1 {
+			t.Errorf("-quiet=%t report = %+v, want 2 records with 1 failed", quiet, got)
+		}
+		if lines := readLines(t, output); len(lines) != 2 {
+			t.Errorf("-quiet=%t wrote %d lines, want 2", quiet, len(lines))
+		}
+	}
+}
+
+func TestRunMapOutput(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	for _, pretty := range []bool{false, true} {
+		cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25"), record("CHEMBL941"), record("CHEMBL25"), record("chembl25")))
+		cfg.outputFormat = "map"
+		cfg.pretty = pretty
+		runOutput(t, cfg)
+		body, err := os.ReadFile(cfg.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]unichem.CompoundID{}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("-pretty=%t: output is not one JSON object: %v\n%s", pretty, err, body)
+		}
+		if len(got) != 2 || got["CHEMBL25"].PubChem != "2244" || got["CHEMBL941"].DrugBank != "DB00619" {
+			t.Errorf("-pretty=%t output = %v, want CHEMBL25 and CHEMBL941 once each", pretty, got)
+		}
+		if n := bytes.Count(body, []byte(`"CHEMBL25":`)); n != 1 {
+			t.Errorf("-pretty=%t output has CHEMBL25 %d times, want once:\n%s", pretty, n, body)
+		}
+	}
+
+	cfg := testConfig(t, srv, writeInput(t, `{"id": "x"}`))
+	cfg.outputFormat = "map"
+	if got := runOutput(t, cfg); fmt.Sprint(got) != "[{}]" {
+		t.Errorf("output without compounds = %q, want {}", got)
+	}
+}
+
+func TestRunOnlyIDs(t *testing.T) {
+	allowlist := writeInput(t, " chembl941", "", "CHEMBL404")
+	allow, err := readAllowlist(defaultConfig(), allowlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(allow, map[string]bool{"CHEMBL941": true, "CHEMBL404": true}) {
+		t.Errorf("allowlist = %v, want CHEMBL941 and CHEMBL404", allow)
+	}
+
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25"), record("CHEMBL941"), record("CHEMBL3"), `{"id": "x"}`))
+	cfg.onlyIDs = allow
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 1 || got[0]["chembl"] != "CHEMBL941" {
+		t.Errorf("output = %v, want only CHEMBL941", got)
+	}
+	if n := srv.total(); n != 1 || srv.requests("CHEMBL941") != 1 {
+		t.Errorf("sent %d lookups, want one of CHEMBL941", n)
+	}
+
+	if _, err := readAllowlist(defaultConfig(), writeInput(t, "CHEMBL25", "DB00945")); err == nil || !strings.Contains(err.Error(), "DB00945") {
+		t.Errorf("allowlist with a DrugBank ID: error = %v, want it named", err)
+	}
+}
+
+func TestLatencySummary(t *testing.T) {
+	l := &latencies{}
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(d time.Duration) {
+			defer wg.Done()
+			l.add(d)
+		}(time.Duration(i) * time.Millisecond)
+	}
+	wg.Wait()
+	want := latencySummary{Requests: 100, P50: 50, P95: 95, P99: 99, Max: 100}
+	if got := l.summary(); *got != want {
+		t.Errorf("summary = %+v, want %+v", *got, want)
+	}
+	if got := (&latencies{}).summary(); *got != (latencySummary{}) {
+		t.Errorf("summary without requests = %+v, want zeroes", *got)
+	}
+}
+
+func TestRunTrace(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		upstream.serve(w, r)
+	}))
+	defer srv.Close()
+
+	lines := []string{}
+	for i := 0; i < 8; i++ {
+		lines = append(lines, record(fmt.Sprintf("CHEMBL%d", 100+i)))
+	}
+	cfg := testConfig(t, upstream, writeInput(t, lines...))
+	cfg.unichemURL = srv.URL
+	cfg.threads = 4
+	cfg.trace = true
+	cfg.reportFile = filepath.Join(t.TempDir(), "report.json")
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	logs := &strings.Builder{}
+	if err := run(cfg, slog.New(slog.NewTextHandler(logs, nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	latency := readReport(t, cfg.reportFile).Latency
+	if latency == nil || latency.Requests != 8 {
+		t.Fatalf("report latency = %+v, want 8 requests", latency)
+	}
+	if latency.P50 < 10 || latency.P50 > latency.P95 || latency.P95 > latency.P99 || latency.P99 > latency.Max {
+		t.Errorf("latency = %+v, want ordered percentiles of at least 10ms", latency)
+	}
+	if !strings.Contains(logs.String(), "8 requests took p50=") {
+		t.Errorf("logs =\n%s\nwant the percentiles in the summary", logs)
+	}
+}
+
+func TestRunOutputGuards(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		existing     bool
+		force        bool
+		noCreateDirs bool
+		wantErr      string
+	}{
+		{name: "existing", output: "out.json", existing: true, wantErr: "already exists; use -force"},
+		{name: "forced", output: "out.json", existing: true, force: true},
+		{name: "new directory", output: "new/out.json"},
+		{name: "new tree", output: "new/deeper/out.json", wantErr: "neither does its parent"},
+		{name: "no new directory", output: "new/out.json", noCreateDirs: true, wantErr: "does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newUniChemServer(t, testCompounds)
+			dir := t.TempDir()
+			cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+			cfg.outputFile = filepath.Join(dir, tt.output)
+			cfg.force = tt.force
+			cfg.noCreateDirs = tt.noCreateDirs
+			if tt.existing {
+				if err := os.WriteFile(cfg.outputFile, []byte("keep me\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := cfg.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := run(cfg, discard)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := decodeLines(t, readLines(t, cfg.outputFile)); len(got) != 1 || got[0]["chembl"] != "CHEMBL25" {
+					t.Errorf("output = %v, want CHEMBL25 alone", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if tt.existing {
+				if got := readLines(t, cfg.outputFile); fmt.Sprint(got) != "[keep me]" {
+					t.Errorf("existing output = %q, want it untouched", got)
+				}
+			} else if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+				t.Errorf("created the output directory: %v", err)
+			}
+			if n := srv.total(); n != 0 {
+				t.Errorf("looked up %d IDs after the output failed, want none", n)
+			}
+		})
+	}
+}
+
+func TestRunAppend(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	output := filepath.Join(t.TempDir(), "out.json")
+	if err := os.WriteFile(output, []byte(`{"chembl":"CHEMBL1"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runAppend := func(cfg config, want ...string) {
+		t.Helper()
+		cfg.outputFile = output
+		cfg.appendOutput = true
+		got := []string{}
+		for _, line := range decodeLines(t, runOutput(t, cfg)) {
+			got = append(got, line["chembl"].(string))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("output = %v, want %v", got, want)
+		}
+	}
+
+	runAppend(testConfig(t, srv, writeInput(t, record("CHEMBL25"), record("CHEMBL941"))), "CHEMBL1", "CHEMBL25", "CHEMBL941")
+	runAppend(testConfig(t, srv, writeInput(t, record("CHEMBL3"))), "CHEMBL1", "CHEMBL25", "CHEMBL941", "CHEMBL3")
+
+	// A resumed run picks up after the records the killed one appended.
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL4"), record("CHEMBL5")))
+	cfg.checkpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	cfg.resume = true
+	first := cfg
+	first.limit = 1
+	runAppend(first, "CHEMBL1", "CHEMBL25", "CHEMBL941", "CHEMBL3", "CHEMBL4")
+	runAppend(cfg, "CHEMBL1", "CHEMBL25", "CHEMBL941", "CHEMBL3", "CHEMBL4", "CHEMBL5")
+	if n := srv.requests("CHEMBL4"); n != 1 {
+		t.Errorf("CHEMBL4 looked up %d times, want once", n)
+	}
+}
+
+// droppingWriter passes records on to recordWriter but loses those of one
+// ChEMBL ID, like a writer with a bug would.
+type droppingWriter struct {
+	recordWriter
+	drop string
+}
+
+func (w *droppingWriter) Write(rec EnrichedRecord) error {
+	if rec.Compound.ChEMBL == w.drop {
+		return nil
+	}
+	return w.recordWriter.Write(rec)
+}
+
+func TestStrictWriter(t *testing.T) {
+	out := &strings.Builder{}
+	pending := map[int64]string{0: "CHEMBL25", 1: "CHEMBL941", 2: "CHEMBL3"}
+	strict := &strictWriter{recordWriter: &jsonWriter{enc: json.NewEncoder(out)}, pending: pending}
+	w := &droppingWriter{recordWriter: strict, drop: "CHEMBL941"}
+	for seq, id := range []string{"CHEMBL25", "CHEMBL941", "CHEMBL3"} {
+		if err := w.Write(EnrichedRecord{Compound: unichem.CompoundID{ChEMBL: id}, seq: int64(seq)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The record lost before the output writer is still pending.
+	if !reflect.DeepEqual(pending, map[int64]string{1: "CHEMBL941"}) {
+		t.Errorf("pending = %v, want CHEMBL941 alone", pending)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Errorf("wrote %d lines, want 2", n)
+	}
+}
+
+func TestRunStrict(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, record("CHEMBL25"), `{"id": "x"}`, record("CHEMBL941"), record("CHEMBL3"))
+	cfg := testConfig(t, srv, input)
+	cfg.strict = true
+	// The record without an ID is written with an error.
+	if got := runOutput(t, cfg); len(got) != 4 {
+		t.Errorf("got %d lines, want 4", len(got))
+	}
+	// Filtered records are accounted for.
+	cfg = testConfig(t, srv, input)
+	cfg.strict = true
+	cfg.onlyIDs = map[string]bool{"CHEMBL25": true, "CHEMBL941": true}
+	if got := runOutput(t, cfg); len(got) != 2 {
+		t.Errorf("got %d lines, want 2", len(got))
+	}
+
+	for name, set := range map[string]func(*config){
+		"dedup":           func(cfg *config) { cfg.dedup = true },
+		"skip-unresolved": func(cfg *config) { cfg.skipUnresolved = true },
+		"map":             func(cfg *config) { cfg.outputFormat = "map" },
+	} {
+		cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+		cfg.strict = true
+		set(&cfg)
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "-strict") {
+			t.Errorf("-strict with %s: error = %v, want it rejected", name, err)
+		}
+	}
+}
+
+func TestRunKeepProvenance(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t,
+		`{"id": "1", "chembl_id": "CHEMBL25", "sources": ["DrugBank", "TTD"], "interaction_types": ["inhibitor"]}`,
+		record("CHEMBL941"))
+	for _, keep := range []bool{false, true} {
+		cfg := testConfig(t, srv, input)
+		cfg.keepProvenance = keep
+		got := decodeLines(t, runOutput(t, cfg))
+		if len(got) != 2 {
+			t.Fatalf("got %d lines, want 2", len(got))
+		}
+		want := map[string]interface{}{"chembl": "CHEMBL25", "drugbank": "DB00945", "chebi": "CHEBI:15365", "pubchem": "2244"}
+		if keep {
+			want["sources"] = []interface{}{"DrugBank", "TTD"}
+			want["interaction_types"] = []interface{}{"inhibitor"}
+		}
+		if !reflect.DeepEqual(got[0], want) {
+			t.Errorf("-keep-provenance=%t: CHEMBL25 = %v, want %v", keep, got[0], want)
+		}
+		// A record without provenance gains no empty fields.
+		if _, ok := got[1]["sources"]; ok {
+			t.Errorf("-keep-provenance=%t: CHEMBL941 = %v, want no sources", keep, got[1])
+		}
+	}
+}
+
+func TestNormalizeAttributes(t *testing.T) {
+	rec := Record{
+		Attributes: []Attribute{
+			{Name: "Mechanism of Action", Value: "Inhibitor", Sources: []string{"ChEMBL"}},
+			{Name: "Direct Interaction", Value: "true", Sources: []string{"TTD"}},
+			{Name: "Mechanism of Action", Value: "Inhibitor", Sources: []string{"DrugBank", "ChEMBL"}},
+			{Name: "Mechanism of Action", Value: "Antagonist", Sources: []string{"TTD"}},
+		},
+		InteractionClaims: []InteractionClaim{{
+			Source: "DrugBank",
+			Attributes: []Attribute{
+				{Name: "PMID", Value: "123"},
+				{Name: "PMID", Value: "456"},
+			},
+		}},
+	}
+	normalizeAttributes(&rec)
+
+	want := []Attribute{
+		{Name: "Mechanism of Action", Value: "Inhibitor", Values: []string{"Inhibitor", "Antagonist"}, Sources: []string{"ChEMBL", "DrugBank", "TTD"}},
+		{Name: "Direct Interaction", Value: "true", Sources: []string{"TTD"}},
+	}
+	if !reflect.DeepEqual(rec.Attributes, want) {
+		t.Errorf("attributes = %+v, want %+v", rec.Attributes, want)
+	}
+	claim := []Attribute{{Name: "PMID", Value: "123", Values: []string{"123", "456"}}}
+	if !reflect.DeepEqual(rec.InteractionClaims[0].Attributes, claim) {
+		t.Errorf("claim attributes = %+v, want %+v", rec.InteractionClaims[0].Attributes, claim)
+	}
+}
+
+func TestRunNormalizeAttributes(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t, `{"id": "1", "chembl_id": "CHEMBL25", "attributes": [`+
+		`{"name": "Mechanism of Action", "value": "Inhibitor", "sources": ["ChEMBL"]}, `+
+		`{"name": "Mechanism of Action", "value": "Inhibitor", "sources": ["DrugBank"]}]}`)
+	for _, normalize := range []bool{false, true} {
+		cfg := testConfig(t, srv, input)
+		cfg.mode = "enrich"
+		cfg.normalizeAttributes = normalize
+		got := decodeLines(t, runOutput(t, cfg))
+		attributes, _ := got[0]["attributes"].([]interface{})
+		if want := map[bool]int{false: 2, true: 1}[normalize]; len(attributes) != want {
+			t.Errorf("-normalize-attributes=%t: attributes = %v, want %d", normalize, attributes, want)
+		}
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	cfg := defaultConfig()
+	transport := newHTTPClient(cfg, nil).Transport.(*http.Transport)
+	if transport.MaxIdleConns != 100 || transport.MaxConnsPerHost != 0 || transport.IdleConnTimeout != 90*time.Second {
+		t.Errorf("default transport keeps %d idle connections, %d per host, for %s; want 100, no limit, 90s",
+			transport.MaxIdleConns, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	cfg.threads = 16
+	cfg.maxIdleConns = 32
+	cfg.maxConnsPerHost = 8
+	cfg.idleTimeout = 5 * time.Second
+	proxy := &url.URL{Scheme: "http", Host: "proxy.invalid:3128"}
+	transport = newHTTPClient(cfg, proxy).Transport.(*http.Transport)
+	got := []interface{}{transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost, transport.IdleConnTimeout}
+	if want := []interface{}{32, 16, 8, 5 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transport limits = %v, want %v", got, want)
+	}
+	req, _ := http.NewRequest("GET", "https://www.ebi.ac.uk/unichem", nil)
+	if u, err := transport.Proxy(req); err != nil || u.String() != proxy.String() {
+		t.Errorf("proxy = %v, %v; want %s", u, err, proxy)
+	}
+}
+
+func TestRunInputURL(t *testing.T) {
+	plain := record("CHEMBL25") + "\n" + record("CHEMBL941") + "\n"
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	io.WriteString(zw, plain)
+	zw.Close()
+	var agent string
+	dumps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.Header.Get("User-Agent")
+		switch r.URL.Path {
+		case "/dump.json":
+			io.WriteString(w, plain)
+		case "/dump.json.gz":
+			w.Write(compressed.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer dumps.Close()
+
+	srv := newUniChemServer(t, testCompounds)
+	for _, name := range []string{"/dump.json", "/dump.json.gz"} {
+		cfg := testConfig(t, srv, dumps.URL+name)
+		got := decodeLines(t, runOutput(t, cfg))
+		if len(got) != 2 || got[0]["pubchem"] != "2244" || got[1]["pubchem"] != "5291" {
+			t.Errorf("%s: output = %v, want CHEMBL25 and CHEMBL941 resolved", name, got)
+		}
+		if !strings.HasPrefix(agent, "dgidb-transform/") {
+			t.Errorf("%s: User-Agent = %q, want dgidb-transform/<version>", name, agent)
+		}
+	}
+
+	cfg := testConfig(t, srv, dumps.URL+"/missing.json")
+	if err := run(cfg, discard); err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Errorf("missing dump: error = %v, want the 404 status", err)
+	}
+
+	// The input is fetched through -proxy like every other request, and
+	// -timeout bounds the wait for it.
+	var mu sync.Mutex
+	hosts := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.Host)
+		mu.Unlock()
+		switch r.Host {
+		case "dumps.invalid":
+			if r.URL.Path == "/slow.json" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			io.WriteString(w, plain)
+		default:
+			srv.serve(w, r)
+		}
+	}))
+	defer proxy.Close()
+	cfg = testConfig(t, srv, "http://dumps.invalid/dump.json")
+	cfg.proxy = proxy.URL
+	if got := decodeLines(t, runOutput(t, cfg)); len(got) != 2 || got[1]["pubchem"] != "5291" {
+		t.Errorf("proxied dump: output = %v, want CHEMBL25 and CHEMBL941 resolved", got)
+	}
+	if len(hosts) == 0 || hosts[0] != "dumps.invalid" {
+		t.Errorf("proxy saw requests for %q, want the input first", hosts)
+	}
+	cfg = testConfig(t, srv, "http://dumps.invalid/slow.json")
+	cfg.proxy = proxy.URL
+	cfg.timeout = 50 * time.Millisecond
+	if err := run(cfg, discard); err == nil || !strings.Contains(err.Error(), "fetching input") {
+		t.Errorf("slow dump: error = %v, want the input fetch to time out", err)
+	}
+}
+
+func TestRunPretty(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25"), record("CHEMBL941")))
+	cfg.outputFormat = "map"
+	cfg.pretty = true
+	lines := runOutput(t, cfg)
+	want := []string{
+		"{",
+		`  "CHEMBL25": {`,
+		`    "chembl": "CHEMBL25",`,
+	}
+	if len(lines) < len(want) || fmt.Sprint(lines[:len(want)]) != fmt.Sprint(want) {
+		t.Errorf("output starts\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	got := map[string]unichem.CompoundID{}
+	if err := json.Unmarshal([]byte(strings.Join(lines, "\n")), &got); err != nil || len(got) != 2 {
+		t.Errorf("indented output = %v, %v; want both compounds", got, err)
+	}
+
+	for _, format := range []string{"json", "jsonpb", "tsv"} {
+		cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+		cfg.outputFormat = format
+		cfg.pretty = true
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "-pretty") {
+			t.Errorf("-pretty with -output-format %s: error = %v, want it rejected", format, err)
+		}
+	}
+}
+
+func TestRunReportSchema(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	srv := failingServer(t, upstream, "CHEMBL404")
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL941"), record("CHEMBL3"), record("CHEMBL404"), `{"id": "x"}`)
+	cfg := testConfig(t, upstream, input)
+	cfg.unichemURL = srv.URL
+	cfg.sources, _ = unichem.ParseSources("2,7,22")
+	cfg.reportFile = filepath.Join(t.TempDir(), "report.json")
+	runOutput(t, cfg)
+
+	body, err := os.ReadFile(cfg.reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := map[string]interface{}{}
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if want := []string{"bad_lines", "cache_hits", "failed", "filtered", "records", "resolved", "seconds", "unresolved"}; fmt.Sprint(keys(report)) != fmt.Sprint(want) {
+		t.Errorf("report keys = %v, want %v", keys(report), want)
+	}
+	for name, want := range map[string]float64{"records": 5, "failed": 1, "unresolved": 1, "bad_lines": 0, "filtered": 0} {
+		if report[name] != want {
+			t.Errorf("%s = %v, want %v", name, report[name], want)
+		}
+	}
+	resolved := map[string]interface{}{"chebi": 1.0, "drugbank": 2.0, "pubchem": 2.0}
+	if !reflect.DeepEqual(report["resolved"], resolved) {
+		t.Errorf("resolved = %v, want %v", report["resolved"], resolved)
+	}
+	if seconds, ok := report["seconds"].(float64); !ok || seconds <= 0 {
+		t.Errorf("seconds = %v, want the run time", report["seconds"])
+	}
+
+	// On stderr the report is one line among the diagnostics.
+	stderr, code := runMain(t, "-input", input, "-output", filepath.Join(t.TempDir(), "out.json"), "-unichem-url", srv.URL,
+		"-sources", "2,7,22", "-retries", "0", "-rate", "0", "-log-level", "error", "-stats-json", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d:\n%s", code, stderr)
+	}
+	got := summary{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stderr)), &got); err != nil || got.Records != 5 || got.Resolved["pubchem"] != 2 {
+		t.Errorf("stderr report = %+v, %v:\n%s", got, err, stderr)
+	}
+}
+
+func TestRunEmitUnresolved(t *testing.T) {
+	upstream := newUniChemServer(t, map[string]map[int]string{
+		"CHEMBL25":  testCompounds["CHEMBL25"],
+		"CHEMBL941": testCompounds["CHEMBL941"],
+		// Mapped, but to none of the selected sources.
+		"CHEMBL2": {7: "28971"},
+	})
+	srv := failingServer(t, upstream, "CHEMBL404")
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL2"), record("CHEMBL3"), record("CHEMBL941"),
+		record("CHEMBL404"), record("chembl3"), `{"id": "x"}`)
+	cfg := testConfig(t, upstream, input)
+	cfg.unichemURL = srv.URL
+	cfg.sources, _ = unichem.ParseSources("2,22")
+	cfg.emitUnresolved = true
+	// Failed lookups and records without an ID are left out, and each ID
+	// is written once.
+	if got := runOutput(t, cfg); fmt.Sprint(got) != "[CHEMBL2 CHEMBL3]" {
+		t.Errorf("output = %q, want CHEMBL2 and CHEMBL3", got)
+	}
+}
+
+func TestRunPubChemFallback(t *testing.T) {
+	var mu sync.Mutex
+	searched := []string{}
+	pubchem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/compound/name/"), "/cids/JSON")
+		mu.Lock()
+		searched = append(searched, name)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch strings.ToUpper(name) {
+		case "ASPIRIN":
+			io.WriteString(w, `{"IdentifierList": {"CID": [2244]}}`)
+		case "MYSTERY":
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"Fault": {"Code": "PUGREST.NotFound", "Message": "No CID found"}}`)
+		default:
+			io.WriteString(w, `{"IdentifierList": {"CID": [5291, 123596]}}`)
+		}
+	}))
+	defer pubchem.Close()
+
+	srv := newUniChemServer(t, map[string]map[int]string{
+		"CHEMBL25": testCompounds["CHEMBL25"],
+		"CHEMBL2":  {2: "DB00001"},
+		"CHEMBL4":  {2: "DB00004"},
+		"CHEMBL5":  {2: "DB00005"},
+	})
+	line := func(chemblID, drug string) string {
+		return fmt.Sprintf(`{"id": %q, "drug_name": %q, "chembl_id": %q}`, chemblID, drug, chemblID)
+	}
+	cfg := testConfig(t, srv, writeInput(t, line("CHEMBL25", "ASPIRIN"), line("CHEMBL2", "aspirin"), line("CHEMBL4", "MYSTERY"), line("CHEMBL5", "IMATINIB")))
+	cfg.pubchemFallback = true
+	cfg.pubchemURL = pubchem.URL
+	cfg.sourceRate = map[string]float64{"pubchem": 0}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	logs := &strings.Builder{}
+	if err := run(cfg, slog.New(slog.NewTextHandler(logs, nil))); err != nil {
+		t.Fatal(err)
+	}
+	got := decodeLines(t, readLines(t, cfg.outputFile))
+	if len(got) != 4 {
+		t.Fatalf("got %d lines, want 4", len(got))
+	}
+
+	// UniChem's own CID is not marked, and only the names of compounds
+	// without one are searched.
+	if got[0]["pubchem"] != "2244" || got[0]["fallbacks"] != nil {
+		t.Errorf("CHEMBL25 = %v, want UniChem's CID", got[0])
+	}
+	if got[1]["pubchem"] != "2244" || fmt.Sprint(got[1]["fallbacks"]) != "[pubchem]" {
+		t.Errorf("CHEMBL2 = %v, want CID 2244 marked as a fallback", got[1])
+	}
+	for _, c := range got[2:] {
+		if c["pubchem"] != nil || c["fallbacks"] != nil || c["error"] != nil {
+			t.Errorf("%s = %v, want no CID and no error", c["chembl"], c)
+		}
+	}
+	if fmt.Sprint(searched) != "[aspirin MYSTERY IMATINIB]" {
+		t.Errorf("searched PubChem for %q, want aspirin, MYSTERY and IMATINIB", searched)
+	}
+	if !strings.Contains(logs.String(), `drug name \"IMATINIB\" is ambiguous in PubChem: 5291, 123596`) {
+		t.Errorf("logs =\n%s\nwant the ambiguous name", logs)
+	}
+}
+
+func TestRunOrdered(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	// Earlier records take longer, so without -ordered later ones finish
+	// first.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var n int
+		fmt.Sscanf(string(body[bytes.Index(body, []byte("CHEMBL"))+len("CHEMBL"):]), "%d", &n)
+		time.Sleep(time.Duration(20-n) * 2 * time.Millisecond)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		upstream.serve(w, r)
+	}))
+	defer srv.Close()
+
+	ids := []string{}
+	lines := []string{}
+	for i := 0; i < 20; i++ {
+		ids = append(ids, fmt.Sprintf("CHEMBL%d", i))
+		lines = append(lines, record(ids[i]))
+	}
+	input := writeInput(t, lines...)
+	order := func(ordered bool) []string {
+		cfg := testConfig(t, upstream, input)
+		cfg.unichemURL = srv.URL
+		cfg.threads = 8
+		cfg.ordered = ordered
+		got := []string{}
+		for _, line := range decodeLines(t, runOutput(t, cfg)) {
+			got = append(got, line["chembl"].(string))
+		}
+		return got
+	}
+	if got := order(true); fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Errorf("-ordered output = %v, want input order %v", got, ids)
+	}
+	// The check above would be vacuous if the pool kept input order anyway.
+	if got := order(false); fmt.Sprint(got) == fmt.Sprint(ids) {
+		t.Errorf("unordered output kept input order; the test does not exercise -ordered")
+	}
+}
+
+func TestRunLargeIDs(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	input := writeInput(t,
+		`{"id": "a", "entrez_id": 3000000000, "chembl_id": "CHEMBL25", "publications": [4294967296, 7]}`,
+		`{"id": "b", "chembl_id": "CHEMBL941", "publications": [1e30]}`,
+	)
+	cfg := testConfig(t, srv, input)
+	cfg.mode = "enrich"
+	cfg.skipBadLines = true
+	logs := &strings.Builder{}
+	if err := run(cfg, slog.New(slog.NewTextHandler(logs, nil))); err != nil {
+		t.Fatal(err)
+	}
+	got := decodeLines(t, readLines(t, cfg.outputFile))
+	// Numbers beyond int32 survive; one beyond int64 is not truncated but
+	// rejects its line, which is logged.
+	if len(got) != 1 || got[0]["entrez_id"] != 3e9 || fmt.Sprint(got[0]["publications"]) != "[4.294967296e+09 7]" {
+		t.Errorf("output = %v, want record a with its IDs intact", got)
+	}
+	if !strings.Contains(logs.String(), `msg="skipping bad input line" line=2`) {
+		t.Errorf("out of range PMID not logged:\n%s", logs)
+	}
+}
+
+func TestDGIdbInteractionRecord(t *testing.T) {
+	var i dgidbInteraction
+	err := json.Unmarshal([]byte(`{
+		"id": "x",
+		"gene": {"name": "PTGS2", "conceptId": "ncbigene:99999999999999999999"},
+		"drug": {"name": "ASPIRIN", "conceptId": "chembl:CHEMBL25"},
+		"publications": [{"pmid": 4294967296}]
+	}`), &i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := &strings.Builder{}
+	rec := i.record(slog.New(slog.NewTextHandler(logs, nil)))
+	if rec.EntrezID != 0 || !reflect.DeepEqual(rec.Publications, []int64{4294967296}) {
+		t.Errorf("record = %+v, want no Entrez ID and PMID 4294967296", rec)
+	}
+	if !strings.Contains(logs.String(), `msg="invalid Entrez ID from DGIdb" interaction=x`) || !strings.Contains(logs.String(), "value out of range") {
+		t.Errorf("out of range Entrez ID not logged:\n%s", logs)
+	}
+}
+
+func TestRunTruncatedGzip(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintln(zw, record(fmt.Sprintf("CHEMBL%d", 1000+i)))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(t.TempDir(), "input.json.gz")
+	if err := os.WriteFile(input, compressed.Bytes()[:compressed.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(t, srv, input)
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	err := run(cfg, discard)
+	var n int
+	if err == nil {
+		t.Fatal("run of a truncated gzip stream succeeded")
+	}
+	if _, scanErr := fmt.Sscanf(err.Error(), "input stream truncated after %d records", &n); scanErr != nil {
+		t.Fatalf("run = %v, want input stream truncated after N records", err)
+	}
+	// The records read before the break are all written, each a whole
+	// record in input order.
+	got := decodeLines(t, readLines(t, cfg.outputFile))
+	if n == 0 || n >= 1000 || len(got) != n {
+		t.Fatalf("wrote %d records and reported %d, want the same number, short of 1000", len(got), n)
+	}
+	for i, v := range got {
+		if want := fmt.Sprintf("CHEMBL%d", 1000+i); v["chembl"] != want {
+			t.Fatalf("line %d = %v, want %s", i+1, v, want)
+		}
+	}
+}
+
+// inFlight tracks the most requests a handler served at once.
+type inFlight struct {
+	mu       sync.Mutex
+	now, max int
+}
+
+// wrap returns h delayed by delay and counted by f.
+func (f *inFlight) wrap(delay time.Duration, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.now++
+		f.max = max(f.max, f.now)
+		f.mu.Unlock()
+		time.Sleep(delay)
+		h(w, r)
+		f.mu.Lock()
+		f.now--
+		f.mu.Unlock()
+	}
+}
+
+func TestRunSourceLimits(t *testing.T) {
+	upstream := newUniChemServer(t, testCompounds)
+	uc := &inFlight{}
+	srv := httptest.NewServer(uc.wrap(50*time.Millisecond, upstream.serve))
+	defer srv.Close()
+	pc := &inFlight{}
+	pubchem := httptest.NewServer(pc.wrap(10*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"IdentifierList": {"CID": [2244]}}`)
+	}))
+	defer pubchem.Close()
+
+	lines := []string{}
+	for i := 0; i < 6; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id": "%d", "drug_name": "DRUG%d", "chembl_id": "CHEMBL%d"}`, i, i, 100+i))
+	}
+	cfg := testConfig(t, upstream, writeInput(t, lines...))
+	cfg.unichemURL = srv.URL
+	cfg.threads = 6
+	cfg.pubchemFallback = true
+	cfg.pubchemURL = pubchem.URL
+	cfg.sourceRate = map[string]float64{"pubchem": 20}
+	cfg.sourceConcurrency = map[string]int{"pubchem": 1}
+
+	start := time.Now()
+	if got := runOutput(t, cfg); len(got) != 6 {
+		t.Fatalf("got %d lines, want 6", len(got))
+	}
+	// Six searches at 20 per second need at least five intervals of 50ms,
+	// and are sent one at a time, while UniChem is not held back.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("6 PubChem searches at pubchem=20 took %s, want at least 250ms", elapsed)
+	}
+	if pc.max != 1 {
+		t.Errorf("%d PubChem searches in flight, want 1", pc.max)
+	}
+	if uc.max < 2 {
+		t.Errorf("%d UniChem lookups in flight, want the PubChem limits not to apply", uc.max)
+	}
+}
+
+func TestParseServiceLimits(t *testing.T) {
+	tests := []struct {
+		list string
+		want map[string]int
+		err  string
+	}{
+		{list: "", want: map[string]int{}},
+		{list: " UniChem=5 , pubchem=0,", want: map[string]int{"unichem": 5, "pubchem": 0}},
+		{list: "unichem", err: `expected service=value`},
+		{list: "rxnav=2", err: `unknown -source-concurrency service "rxnav"`},
+		{list: "chembl=-1", err: `invalid -source-concurrency value "-1" for chembl`},
+		{list: "chembl=fast", err: `invalid -source-concurrency value "fast" for chembl`},
+	}
+	for _, tt := range tests {
+		got, err := parseServiceLimits("-source-concurrency", tt.list, strconv.Atoi)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseServiceLimits(%q) = %v, want an error containing %s", tt.list, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseServiceLimits(%q) = %v, %v, want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestRunKeepRaw(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("..", "..", "unichem", "testdata", "v1_CHEMBL25.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	for _, mode := range []string{"ids", "enrich"} {
+		cfg := testConfig(t, newUniChemServer(t, nil), writeInput(t, record("CHEMBL25")))
+		cfg.unichemURL = srv.URL
+		cfg.mode = mode
+		cfg.keepRaw = true
+		lines := runOutput(t, cfg)
+		if len(lines) != 1 {
+			t.Fatalf("-mode %s: got %d lines, want 1", mode, len(lines))
+		}
+		var out map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(lines[0]), &out); err != nil {
+			t.Fatal(err)
+		}
+		compound := out
+		if mode == "enrich" {
+			if err := json.Unmarshal(out["compound"], &compound); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Each output record is one line, so only the whitespace between
+		// tokens may change.
+		want := &bytes.Buffer{}
+		if err := json.Compact(want, body); err != nil {
+			t.Fatal(err)
+		}
+		if string(compound["_raw"]) != want.String() {
+			t.Errorf("-mode %s _raw = %s, want the UniChem response %s", mode, compound["_raw"], want)
+		}
+	}
+
+	// It is off by default.
+	cfg := testConfig(t, newUniChemServer(t, nil), writeInput(t, record("CHEMBL25")))
+	cfg.unichemURL = srv.URL
+	if got := decodeLines(t, runOutput(t, cfg)); len(got) != 1 || got[0]["_raw"] != nil {
+		t.Errorf("output = %v, want no _raw without -keep-raw", got)
+	}
+}
+
+func TestValidateIDField(t *testing.T) {
+	tests := []struct {
+		field   string
+		source  string
+		wantErr string
+	}{
+		{field: "chembl_id", source: "1"},
+		{field: "attributes.ChEMBL", source: "1"},
+		{field: "drug_name", source: "22"},
+		{field: "drug_name", source: "1", wantErr: "holds names, not ChEMBL IDs"},
+		{field: "attributes.", source: "1", wantErr: "unknown -id-field"},
+		{field: "gene_name", source: "1", wantErr: "unknown -id-field"},
+	}
+	for _, tt := range tests {
+		cfg := defaultConfig()
+		cfg.inputFormat = "ndjson"
+		cfg.idField = tt.field
+		cfg.inputSource = tt.source
+		err := cfg.validate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("-id-field %s -input-source %s: %v", tt.field, tt.source, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("-id-field %s -input-source %s: error = %v, want one containing %q", tt.field, tt.source, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunIDFieldAttribute(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	attribute := func(id, name, value string) string {
+		return fmt.Sprintf(`{"id": %q, "drug_name": "ASPIRIN", "chembl_id": "CHEMBL941", "attributes": [{"name": "Source", "value": "x"}, {"name": %q, "value": %q}]}`, id, name, value)
+	}
+	input := writeInput(t, attribute("a", "chembl", "CHEMBL25"), attribute("b", "ChEMBL", "CHEMBL25"), attribute("c", "other", "CHEMBL25"))
+	cfg := testConfig(t, srv, input)
+	cfg.idField = "attributes.chembl"
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 3 {
+		t.Fatalf("got %d lines, want 3", len(got))
+	}
+	// The attribute is matched whatever its case and wins over chembl_id.
+	for _, c := range got[:2] {
+		if c["chembl"] != "CHEMBL25" || c["pubchem"] != "2244" {
+			t.Errorf("output = %v, want CHEMBL25 from the attribute", c)
+		}
+	}
+	if got[2]["error"] != "record has no attributes.chembl" {
+		t.Errorf("record without the attribute = %v, want an error naming it", got[2])
+	}
+	if n := srv.requests("CHEMBL941"); n != 0 {
+		t.Errorf("chembl_id looked up %d times, want 0", n)
+	}
+}
+
+// dgidbServer is a mock of the DGIdb GraphQL API that serves pages, one
+// per request in order, and records the variables of each request.
+func dgidbServer(t *testing.T, pages ...string) (*httptest.Server, *[]map[string]interface{}) {
+	var mu sync.Mutex
+	variables := []map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}{}
+		if r.Method != "POST" || json.NewDecoder(r.Body).Decode(&req) != nil || !strings.Contains(req.Query, "interactions(") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(variables) >= len(pages) {
+			http.Error(w, "no more pages", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, pages[len(variables)])
+		variables = append(variables, req.Variables)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &variables
+}
+
+func TestRunFromDGIdb(t *testing.T) {
+	dgidbAPI, variables := dgidbServer(t,
+		`{"data": {"interactions": {"pageInfo": {"endCursor": "c1", "hasNextPage": true}, "nodes": [
+			{"id": "i1", "gene": {"name": "PTGS2", "conceptId": "ncbigene:5743"}, "drug": {"name": "ASPIRIN", "conceptId": "chembl:CHEMBL25"},
+			 "interactionTypes": [{"type": "inhibitor"}], "publications": [{"pmid": 12345}], "sources": [{"sourceDbName": "DrugBank"}]}
+		]}}}`,
+		`{"data": {"interactions": {"pageInfo": {"endCursor": "c2", "hasNextPage": false}, "nodes": [
+			{"id": "i2", "gene": {"name": "ABL1", "conceptId": "ncbigene:25"}, "drug": {"name": "IMATINIB", "conceptId": "CHEMBL:chembl941"}}
+		]}}}`,
+	)
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, "")
+	cfg.fromDGIdb = true
+	cfg.dgidbURL = dgidbAPI.URL
+	cfg.dgidbGenes = []string{"PTGS2", "ABL1"}
+	cfg.mode = "enrich"
+	got := decodeLines(t, runOutput(t, cfg))
+
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want one per interaction", len(got))
+	}
+	first := got[0]
+	if first["id"] != "i1" || first["gene_name"] != "PTGS2" || first["entrez_id"] != 5743.0 || first["chembl_id"] != "CHEMBL25" ||
+		fmt.Sprint(first["interaction_types"]) != "[inhibitor]" || fmt.Sprint(first["publications"]) != "[12345]" || fmt.Sprint(first["sources"]) != "[DrugBank]" {
+		t.Errorf("first record = %v, want interaction i1 in the Record shape", first)
+	}
+	if compound, _ := got[1]["compound"].(map[string]interface{}); got[1]["chembl_id"] != "CHEMBL941" || compound["pubchem"] != "5291" {
+		t.Errorf("second record = %v, want CHEMBL941 resolved", got[1])
+	}
+
+	// The second page is asked for after the cursor of the first, with the
+	// same gene filter.
+	if len(*variables) != 2 {
+		t.Fatalf("fetched %d pages, want 2", len(*variables))
+	}
+	for i, v := range *variables {
+		if fmt.Sprint(v["geneNames"]) != "[PTGS2 ABL1]" || v["drugNames"] != nil {
+			t.Errorf("page %d variables = %v, want the gene names alone", i+1, v)
+		}
+	}
+	if (*variables)[0]["after"] != nil || (*variables)[1]["after"] != "c1" {
+		t.Errorf("cursors = %v and %v, want none then c1", (*variables)[0]["after"], (*variables)[1]["after"])
+	}
+}
+
+func TestRunFromDGIdbErrors(t *testing.T) {
+	dgidbAPI, _ := dgidbServer(t, `{"errors": [{"message": "unknown argument"}, {"message": "try again"}]}`)
+	cfg := testConfig(t, newUniChemServer(t, testCompounds), "")
+	cfg.fromDGIdb = true
+	cfg.dgidbURL = dgidbAPI.URL
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	err := run(cfg, discard)
+	if err == nil || !strings.Contains(err.Error(), "fetching DGIdb interactions page 1: unknown argument; try again") {
+		t.Errorf("run = %v, want the GraphQL errors", err)
+	}
+}
+
+func TestRunWithRxNorm(t *testing.T) {
+	aspirin, err := os.ReadFile(filepath.Join("testdata", "rxnorm_DB00945.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	queries := []string{}
+	rxnorm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rxcui.json" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		mu.Lock()
+		queries = append(queries, q.Encode())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case q.Get("id") == "DB00945":
+			w.Write(aspirin)
+		case q.Get("name") == "Imatinib Mesylate":
+			io.WriteString(w, `{"idGroup":{"name":"Imatinib Mesylate","rxnormId":["284635","1546356","284635"]}}`)
+		default:
+			io.WriteString(w, `{"idGroup":{"idType":"DRUGBANK","id":"`+q.Get("id")+`"}}`)
+		}
+	}))
+	defer rxnorm.Close()
+
+	srv := newUniChemServer(t, map[string]map[int]string{
+		"CHEMBL25":  testCompounds["CHEMBL25"],
+		"CHEMBL2":   {2: "DB00002"},
+		"CHEMBL941": {22: "5291"},
+	})
+	input := writeInput(t, record("CHEMBL25"), record("CHEMBL2"), `{"id": "x", "drug_name": "Imatinib Mesylate", "chembl_id": "CHEMBL941"}`)
+	cfg := testConfig(t, srv, input)
+	cfg.withRxNorm = true
+	cfg.rxnormURL = rxnorm.URL
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 3 {
+		t.Fatalf("got %d lines, want 3", len(got))
+	}
+
+	if got[0]["rxnorm"] != "1191" || got[0]["ids"] != nil {
+		t.Errorf("CHEMBL25 = %v, want RxNorm 1191 from its DrugBank ID", got[0])
+	}
+	// A DrugBank ID RxNorm does not know yields no CUI and no error.
+	if got[1]["rxnorm"] != nil || got[1]["error"] != nil {
+		t.Errorf("CHEMBL2 = %v, want no RxNorm CUI", got[1])
+	}
+	// Without a DrugBank ID the drug name is searched, and every distinct
+	// CUI is listed.
+	ids, _ := got[2]["ids"].(map[string]interface{})
+	if got[2]["rxnorm"] != "1546356" || fmt.Sprint(ids["rxnorm"]) != "[1546356 284635]" {
+		t.Errorf("CHEMBL941 = %v, want RxNorm 1546356 with 284635 listed", got[2])
+	}
+	sort.Strings(queries)
+	if want := "[id=DB00002&idtype=DRUGBANK id=DB00945&idtype=DRUGBANK name=Imatinib+Mesylate]"; fmt.Sprint(queries) != want {
+		t.Errorf("queries = %v, want %s", queries, want)
+	}
+}
+
+func TestRunMaxResponseSize(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	cfg := testConfig(t, srv, writeInput(t, record("CHEMBL25")))
+	cfg.maxResponseSize = 10
+	got := decodeLines(t, runOutput(t, cfg))
+	if len(got) != 1 || !strings.Contains(fmt.Sprint(got[0]["error"]), "response larger than 10 bytes") || got[0]["pubchem"] != nil {
+		t.Errorf("output = %v, want CHEMBL25 failed by the size cap", got)
+	}
+
+	cfg.maxResponseSize = 0
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "-max-response-size must be at least 1") {
+		t.Errorf("validate = %v, want -max-response-size 0 rejected", err)
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"out.json", "out.002.json"},
+		{"out.json.gz", "out.002.json.gz"},
+		{"dir.v1/out", "dir.v1/out.002"},
+		{"out.tsv", "out.002.tsv"},
+	}
+	for _, tt := range tests {
+		if got := splitName(tt.name, 2); got != tt.want {
+			t.Errorf("splitName(%q, 2) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunSplit(t *testing.T) {
+	srv := newUniChemServer(t, testCompounds)
+	lines := []string{}
+	for i := 0; i < 10; i++ {
+		// Every ChEMBL ID appears twice, so -split-by key has something to
+		// keep together.
+		lines = append(lines, fmt.Sprintf(`{"id": "r%d", "gene_name": "G%d", "chembl_id": "CHEMBL%d"}`, i, i, 100+i%5))
+	}
+	input := writeInput(t, lines...)
+	whole := testConfig(t, srv, input)
+	whole.mode = "enrich"
+	want := runOutput(t, whole)
+	sort.Strings(want)
+
+	for _, by := range []string{"record", "key"} {
+		for _, output := range []string{"out.json", "out.json.gz"} {
+			t.Run(by+" "+output, func(t *testing.T) {
+				// Two runs into separate directories must split alike.
+				var runs [][][]string
+				for n := 0; n < 2; n++ {
+					cfg := testConfig(t, srv, input)
+					cfg.mode = "enrich"
+					cfg.outputFile = filepath.Join(t.TempDir(), output)
+					cfg.split = 3
+					cfg.splitBy = by
+					if err := cfg.validate(); err != nil {
+						t.Fatal(err)
+					}
+					if err := run(cfg, discard); err != nil {
+						t.Fatal(err)
+					}
+					if _, err := os.Stat(cfg.outputFile); !os.IsNotExist(err) {
+						t.Errorf("-split also wrote %s", cfg.outputFile)
+					}
+					parts := [][]string{}
+					for i := 0; i < 3; i++ {
+						name := splitName(cfg.outputFile, i)
+						if strings.HasSuffix(name, ".gz") {
+							parts = append(parts, gunzipLines(t, name))
+						} else {
+							parts = append(parts, readLines(t, name))
+						}
+					}
+					runs = append(runs, parts)
+				}
+				if !reflect.DeepEqual(runs[0], runs[1]) {
+					t.Errorf("two runs split differently:\n%v\n%v", runs[0], runs[1])
+				}
+
+				got := []string{}
+				partOf := map[interface{}]int{}
+				for i, part := range runs[0] {
+					got = append(got, part...)
+					if by == "record" && (len(part) < 3 || len(part) > 4) {
+						t.Errorf("part %d has %d records, want 3 or 4 of 10", i, len(part))
+					}
+					for _, v := range decodeLines(t, part) {
+						id := v["chembl_id"]
+						if j, ok := partOf[id]; ok && j != i && by == "key" {
+							t.Errorf("%s is in parts %d and %d", id, j, i)
+						}
+						partOf[id] = i
+					}
+				}
+				// The parts reassemble to the unsplit output.
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("parts hold\n%v\nwant\n%v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDryRunFromDGIdbProxy(t *testing.T) {
+	dgidbAPI, _ := dgidbServer(t, `{"data": {"interactions": {"pageInfo": {"hasNextPage": false}, "nodes": [
+		{"id": "i1", "gene": {"name": "PTGS2"}, "drug": {"name": "ASPIRIN", "conceptId": "chembl:CHEMBL25"}}
+	]}}}`)
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		dgidbAPI.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	cfg := testConfig(t, newUniChemServer(t, testCompounds), "")
+	cfg.fromDGIdb = true
+	cfg.dryRun = true
+	// Only the proxy can reach this host.
+	cfg.dgidbURL = "http://dgidb.invalid/api/graphql"
+	cfg.proxy = proxy.URL
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	logs := &strings.Builder{}
+	if err := dryRun(cfg, slog.New(slog.NewTextHandler(logs, nil))); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(hosts) != "[dgidb.invalid]" {
+		t.Errorf("proxy saw requests for %q, want one for dgidb.invalid", hosts)
+	}
+	if !strings.Contains(logs.String(), "records=1 unique_ids=1") {
+		t.Errorf("dry run logged\n%s\nwant one record", logs)
+	}
+}
@@ -0,0 +1,906 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: dgidb.proto
+
+// Messages mirroring the JSON written by dgidb-transform. Records written
+// with -output-format jsonpb follow the proto3 JSON mapping of these
+// messages, so they can be parsed with protojson/jsonpb.
+
+package dgidb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Attribute struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Name    string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value   string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Sources []string               `protobuf:"bytes,3,rep,name=sources,proto3" json:"sources,omitempty"`
+	// values is only set with -normalize-attributes, on attributes merged
+	// from entries with different values.
+	Values        []string `protobuf:"bytes,4,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Attribute) Reset() {
+	*x = Attribute{}
+	mi := &file_dgidb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Attribute) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Attribute) ProtoMessage() {}
+
+func (x *Attribute) ProtoReflect() protoreflect.Message {
+	mi := &file_dgidb_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Attribute.ProtoReflect.Descriptor instead.
+func (*Attribute) Descriptor() ([]byte, []int) {
+	return file_dgidb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Attribute) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Attribute) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *Attribute) GetSources() []string {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+func (x *Attribute) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type InteractionClaim struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Source           string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Drug             string                 `protobuf:"bytes,2,opt,name=drug,proto3" json:"drug,omitempty"`
+	Gene             string                 `protobuf:"bytes,3,opt,name=gene,proto3" json:"gene,omitempty"`
+	InteractionTypes []string               `protobuf:"bytes,4,rep,name=interaction_types,json=interactionTypes,proto3" json:"interaction_types,omitempty"`
+	Attributes       []*Attribute           `protobuf:"bytes,5,rep,name=attributes,proto3" json:"attributes,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *InteractionClaim) Reset() {
+	*x = InteractionClaim{}
+	mi := &file_dgidb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InteractionClaim) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InteractionClaim) ProtoMessage() {}
+
+func (x *InteractionClaim) ProtoReflect() protoreflect.Message {
+	mi := &file_dgidb_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InteractionClaim.ProtoReflect.Descriptor instead.
+func (*InteractionClaim) Descriptor() ([]byte, []int) {
+	return file_dgidb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InteractionClaim) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *InteractionClaim) GetDrug() string {
+	if x != nil {
+		return x.Drug
+	}
+	return ""
+}
+
+func (x *InteractionClaim) GetGene() string {
+	if x != nil {
+		return x.Gene
+	}
+	return ""
+}
+
+func (x *InteractionClaim) GetInteractionTypes() []string {
+	if x != nil {
+		return x.InteractionTypes
+	}
+	return nil
+}
+
+func (x *InteractionClaim) GetAttributes() []*Attribute {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+// Record is a DGIdb drug-gene interaction. compound is only set in
+// -mode enrich and gene only with -enrich-genes.
+type Record struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Id                string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	GeneName          string                 `protobuf:"bytes,2,opt,name=gene_name,json=geneName,proto3" json:"gene_name,omitempty"`
+	EntrezId          int64                  `protobuf:"varint,3,opt,name=entrez_id,json=entrezId,proto3" json:"entrez_id,omitempty"`
+	DrugName          string                 `protobuf:"bytes,4,opt,name=drug_name,json=drugName,proto3" json:"drug_name,omitempty"`
+	ChemblId          string                 `protobuf:"bytes,5,opt,name=chembl_id,json=chemblId,proto3" json:"chembl_id,omitempty"`
+	Publications      []int64                `protobuf:"varint,6,rep,packed,name=publications,proto3" json:"publications,omitempty"`
+	InteractionTypes  []string               `protobuf:"bytes,7,rep,name=interaction_types,json=interactionTypes,proto3" json:"interaction_types,omitempty"`
+	Sources           []string               `protobuf:"bytes,8,rep,name=sources,proto3" json:"sources,omitempty"`
+	Attributes        []*Attribute           `protobuf:"bytes,9,rep,name=attributes,proto3" json:"attributes,omitempty"`
+	InteractionClaims []*InteractionClaim    `protobuf:"bytes,10,rep,name=interaction_claims,json=interactionClaims,proto3" json:"interaction_claims,omitempty"`
+	Compound          *CompoundID            `protobuf:"bytes,11,opt,name=compound,proto3" json:"compound,omitempty"`
+	Gene              *GeneID                `protobuf:"bytes,12,opt,name=gene,proto3" json:"gene,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Record) Reset() {
+	*x = Record{}
+	mi := &file_dgidb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Record) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Record) ProtoMessage() {}
+
+func (x *Record) ProtoReflect() protoreflect.Message {
+	mi := &file_dgidb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Record.ProtoReflect.Descriptor instead.
+func (*Record) Descriptor() ([]byte, []int) {
+	return file_dgidb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Record) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Record) GetGeneName() string {
+	if x != nil {
+		return x.GeneName
+	}
+	return ""
+}
+
+func (x *Record) GetEntrezId() int64 {
+	if x != nil {
+		return x.EntrezId
+	}
+	return 0
+}
+
+func (x *Record) GetDrugName() string {
+	if x != nil {
+		return x.DrugName
+	}
+	return ""
+}
+
+func (x *Record) GetChemblId() string {
+	if x != nil {
+		return x.ChemblId
+	}
+	return ""
+}
+
+func (x *Record) GetPublications() []int64 {
+	if x != nil {
+		return x.Publications
+	}
+	return nil
+}
+
+func (x *Record) GetInteractionTypes() []string {
+	if x != nil {
+		return x.InteractionTypes
+	}
+	return nil
+}
+
+func (x *Record) GetSources() []string {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+func (x *Record) GetAttributes() []*Attribute {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+func (x *Record) GetInteractionClaims() []*InteractionClaim {
+	if x != nil {
+		return x.InteractionClaims
+	}
+	return nil
+}
+
+func (x *Record) GetCompound() *CompoundID {
+	if x != nil {
+		return x.Compound
+	}
+	return nil
+}
+
+func (x *Record) GetGene() *GeneID {
+	if x != nil {
+		return x.Gene
+	}
+	return nil
+}
+
+// GeneID holds the identifiers MyGene.info maps an Entrez gene to.
+type GeneID struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ensembl       string                 `protobuf:"bytes,1,opt,name=ensembl,proto3" json:"ensembl,omitempty"`
+	Hgnc          string                 `protobuf:"bytes,2,opt,name=hgnc,proto3" json:"hgnc,omitempty"`
+	Symbol        string                 `protobuf:"bytes,3,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GeneID) Reset() {
+	*x = GeneID{}
+	mi := &file_dgidb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GeneID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GeneID) ProtoMessage() {}
+
+func (x *GeneID) ProtoReflect() protoreflect.Message {
+	mi := &file_dgidb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GeneID.ProtoReflect.Descriptor instead.
+func (*GeneID) Descriptor() ([]byte, []int) {
+	return file_dgidb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GeneID) GetEnsembl() string {
+	if x != nil {
+		return x.Ensembl
+	}
+	return ""
+}
+
+func (x *GeneID) GetHgnc() string {
+	if x != nil {
+		return x.Hgnc
+	}
+	return ""
+}
+
+func (x *GeneID) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+// CompoundID holds the external IDs UniChem maps a compound to.
+type CompoundID struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Chembl      string                 `protobuf:"bytes,1,opt,name=chembl,proto3" json:"chembl,omitempty"`
+	Pubchem     string                 `protobuf:"bytes,2,opt,name=pubchem,proto3" json:"pubchem,omitempty"`
+	Drugbank    string                 `protobuf:"bytes,3,opt,name=drugbank,proto3" json:"drugbank,omitempty"`
+	Chebi       string                 `protobuf:"bytes,4,opt,name=chebi,proto3" json:"chebi,omitempty"`
+	Kegg        string                 `protobuf:"bytes,5,opt,name=kegg,proto3" json:"kegg,omitempty"`
+	Drugcentral string                 `protobuf:"bytes,6,opt,name=drugcentral,proto3" json:"drugcentral,omitempty"`
+	Bindingdb   string                 `protobuf:"bytes,7,opt,name=bindingdb,proto3" json:"bindingdb,omitempty"`
+	Gtopdb      string                 `protobuf:"bytes,8,opt,name=gtopdb,proto3" json:"gtopdb,omitempty"`
+	Hmdb        string                 `protobuf:"bytes,9,opt,name=hmdb,proto3" json:"hmdb,omitempty"`
+	Fdasrs      string                 `protobuf:"bytes,10,opt,name=fdasrs,proto3" json:"fdasrs,omitempty"`
+	Pharmgkb    string                 `protobuf:"bytes,11,opt,name=pharmgkb,proto3" json:"pharmgkb,omitempty"`
+	Zinc        string                 `protobuf:"bytes,12,opt,name=zinc,proto3" json:"zinc,omitempty"`
+	Comptox     string                 `protobuf:"bytes,13,opt,name=comptox,proto3" json:"comptox,omitempty"`
+	Lipidmaps   string                 `protobuf:"bytes,14,opt,name=lipidmaps,proto3" json:"lipidmaps,omitempty"`
+	Error       string                 `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	// all_sources is only set with -all-sources.
+	AllSources map[string]string `protobuf:"bytes,16,rep,name=all_sources,json=allSources,proto3" json:"all_sources,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// ids lists every ID of a source that maps to more than one, keyed by the
+	// name of the field holding the first.
+	Ids            map[string]*IDList `protobuf:"bytes,17,rep,name=ids,proto3" json:"ids,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Dailymed       string             `protobuf:"bytes,18,opt,name=dailymed,proto3" json:"dailymed,omitempty"`
+	Clinicaltrials string             `protobuf:"bytes,19,opt,name=clinicaltrials,proto3" json:"clinicaltrials,omitempty"`
+	Surechembl     string             `protobuf:"bytes,20,opt,name=surechembl,proto3" json:"surechembl,omitempty"`
+	// inchikey is only set with -with-structure.
+	Inchikey string `protobuf:"bytes,21,opt,name=inchikey,proto3" json:"inchikey,omitempty"`
+	// atc is only set with -with-atc.
+	Atc []string `protobuf:"bytes,22,rep,name=atc,proto3" json:"atc,omitempty"`
+	// full_mwt and molecular_formula are only set with -with-properties.
+	FullMwt          float64 `protobuf:"fixed64,23,opt,name=full_mwt,json=fullMwt,proto3" json:"full_mwt,omitempty"`
+	MolecularFormula string  `protobuf:"bytes,24,opt,name=molecular_formula,json=molecularFormula,proto3" json:"molecular_formula,omitempty"`
+	// source_versions is only set with -with-source-meta.
+	SourceVersions map[string]string `protobuf:"bytes,25,rep,name=source_versions,json=sourceVersions,proto3" json:"source_versions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Pdb            string            `protobuf:"bytes,26,opt,name=pdb,proto3" json:"pdb,omitempty"`
+	Emolecules     string            `protobuf:"bytes,27,opt,name=emolecules,proto3" json:"emolecules,omitempty"`
+	Molport        string            `protobuf:"bytes,28,opt,name=molport,proto3" json:"molport,omitempty"`
+	Lincs          string            `protobuf:"bytes,29,opt,name=lincs,proto3" json:"lincs,omitempty"`
+	// connectivity is only set with -connectivity.
+	Connectivity map[string]*IDList `protobuf:"bytes,30,rep,name=connectivity,proto3" json:"connectivity,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// fallbacks names the fields filled from a service other than UniChem,
+	// e.g. pubchem with -pubchem-fallback.
+	Fallbacks []string `protobuf:"bytes,31,rep,name=fallbacks,proto3" json:"fallbacks,omitempty"`
+	// obsolete is only set with -include-obsolete.
+	Obsolete map[string]*IDList `protobuf:"bytes,32,rep,name=obsolete,proto3" json:"obsolete,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// raw is the UniChem response the IDs were read from, only set with
+	// -keep-raw.
+	Raw *structpb.Value `protobuf:"bytes,33,opt,name=raw,json=_raw,proto3" json:"raw,omitempty"`
+	// rxnorm is only set with -with-rxnorm.
+	Rxnorm        string `protobuf:"bytes,34,opt,name=rxnorm,proto3" json:"rxnorm,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompoundID) Reset() {
+	*x = CompoundID{}
+	mi := &file_dgidb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompoundID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompoundID) ProtoMessage() {}
+
+func (x *CompoundID) ProtoReflect() protoreflect.Message {
+	mi := &file_dgidb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompoundID.ProtoReflect.Descriptor instead.
+func (*CompoundID) Descriptor() ([]byte, []int) {
+	return file_dgidb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CompoundID) GetChembl() string {
+	if x != nil {
+		return x.Chembl
+	}
+	return ""
+}
+
+func (x *CompoundID) GetPubchem() string {
+	if x != nil {
+		return x.Pubchem
+	}
+	return ""
+}
+
+func (x *CompoundID) GetDrugbank() string {
+	if x != nil {
+		return x.Drugbank
+	}
+	return ""
+}
+
+func (x *CompoundID) GetChebi() string {
+	if x != nil {
+		return x.Chebi
+	}
+	return ""
+}
+
+func (x *CompoundID) GetKegg() string {
+	if x != nil {
+		return x.Kegg
+	}
+	return ""
+}
+
+func (x *CompoundID) GetDrugcentral() string {
+	if x != nil {
+		return x.Drugcentral
+	}
+	return ""
+}
+
+func (x *CompoundID) GetBindingdb() string {
+	if x != nil {
+		return x.Bindingdb
+	}
+	return ""
+}
+
+func (x *CompoundID) GetGtopdb() string {
+	if x != nil {
+		return x.Gtopdb
+	}
+	return ""
+}
+
+func (x *CompoundID) GetHmdb() string {
+	if x != nil {
+		return x.Hmdb
+	}
+	return ""
+}
+
+func (x *CompoundID) GetFdasrs() string {
+	if x != nil {
+		return x.Fdasrs
+	}
+	return ""
+}
+
+func (x *CompoundID) GetPharmgkb() string {
+	if x != nil {
+		return x.Pharmgkb
+	}
+	return ""
+}
+
+func (x *CompoundID) GetZinc() string {
+	if x != nil {
+		return x.Zinc
+	}
+	return ""
+}
+
+func (x *CompoundID) GetComptox() string {
+	if x != nil {
+		return x.Comptox
+	}
+	return ""
+}
+
+func (x *CompoundID) GetLipidmaps() string {
+	if x != nil {
+		return x.Lipidmaps
+	}
+	return ""
+}
+
+func (x *CompoundID) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CompoundID) GetAllSources() map[string]string {
+	if x != nil {
+		return x.AllSources
+	}
+	return nil
+}
+
+func (x *CompoundID) GetIds() map[string]*IDList {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+func (x *CompoundID) GetDailymed() string {
+	if x != nil {
+		return x.Dailymed
+	}
+	return ""
+}
+
+func (x *CompoundID) GetClinicaltrials() string {
+	if x != nil {
+		return x.Clinicaltrials
+	}
+	return ""
+}
+
+func (x *CompoundID) GetSurechembl() string {
+	if x != nil {
+		return x.Surechembl
+	}
+	return ""
+}
+
+func (x *CompoundID) GetInchikey() string {
+	if x != nil {
+		return x.Inchikey
+	}
+	return ""
+}
+
+func (x *CompoundID) GetAtc() []string {
+	if x != nil {
+		return x.Atc
+	}
+	return nil
+}
+
+func (x *CompoundID) GetFullMwt() float64 {
+	if x != nil {
+		return x.FullMwt
+	}
+	return 0
+}
+
+func (x *CompoundID) GetMolecularFormula() string {
+	if x != nil {
+		return x.MolecularFormula
+	}
+	return ""
+}
+
+func (x *CompoundID) GetSourceVersions() map[string]string {
+	if x != nil {
+		return x.SourceVersions
+	}
+	return nil
+}
+
+func (x *CompoundID) GetPdb() string {
+	if x != nil {
+		return x.Pdb
+	}
+	return ""
+}
+
+func (x *CompoundID) GetEmolecules() string {
+	if x != nil {
+		return x.Emolecules
+	}
+	return ""
+}
+
+func (x *CompoundID) GetMolport() string {
+	if x != nil {
+		return x.Molport
+	}
+	return ""
+}
+
+func (x *CompoundID) GetLincs() string {
+	if x != nil {
+		return x.Lincs
+	}
+	return ""
+}
+
+func (x *CompoundID) GetConnectivity() map[string]*IDList {
+	if x != nil {
+		return x.Connectivity
+	}
+	return nil
+}
+
+func (x *CompoundID) GetFallbacks() []string {
+	if x != nil {
+		return x.Fallbacks
+	}
+	return nil
+}
+
+func (x *CompoundID) GetObsolete() map[string]*IDList {
+	if x != nil {
+		return x.Obsolete
+	}
+	return nil
+}
+
+func (x *CompoundID) GetRaw() *structpb.Value {
+	if x != nil {
+		return x.Raw
+	}
+	return nil
+}
+
+func (x *CompoundID) GetRxnorm() string {
+	if x != nil {
+		return x.Rxnorm
+	}
+	return ""
+}
+
+type IDList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []string               `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IDList) Reset() {
+	*x = IDList{}
+	mi := &file_dgidb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IDList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IDList) ProtoMessage() {}
+
+func (x *IDList) ProtoReflect() protoreflect.Message {
+	mi := &file_dgidb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IDList.ProtoReflect.Descriptor instead.
+func (*IDList) Descriptor() ([]byte, []int) {
+	return file_dgidb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *IDList) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+var File_dgidb_proto protoreflect.FileDescriptor
+
+const file_dgidb_proto_rawDesc = "" +
+	"\n" +
+	"\vdgidb.proto\x12\x05dgidb\x1a\x1cgoogle/protobuf/struct.proto\"g\n" +
+	"\tAttribute\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\x12\x18\n" +
+	"\asources\x18\x03 \x03(\tR\asources\x12\x16\n" +
+	"\x06values\x18\x04 \x03(\tR\x06values\"\xb1\x01\n" +
+	"\x10InteractionClaim\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x12\n" +
+	"\x04drug\x18\x02 \x01(\tR\x04drug\x12\x12\n" +
+	"\x04gene\x18\x03 \x01(\tR\x04gene\x12+\n" +
+	"\x11interaction_types\x18\x04 \x03(\tR\x10interactionTypes\x120\n" +
+	"\n" +
+	"attributes\x18\x05 \x03(\v2\x10.dgidb.AttributeR\n" +
+	"attributes\"\xc3\x03\n" +
+	"\x06Record\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tgene_name\x18\x02 \x01(\tR\bgeneName\x12\x1b\n" +
+	"\tentrez_id\x18\x03 \x01(\x03R\bentrezId\x12\x1b\n" +
+	"\tdrug_name\x18\x04 \x01(\tR\bdrugName\x12\x1b\n" +
+	"\tchembl_id\x18\x05 \x01(\tR\bchemblId\x12\"\n" +
+	"\fpublications\x18\x06 \x03(\x03R\fpublications\x12+\n" +
+	"\x11interaction_types\x18\a \x03(\tR\x10interactionTypes\x12\x18\n" +
+	"\asources\x18\b \x03(\tR\asources\x120\n" +
+	"\n" +
+	"attributes\x18\t \x03(\v2\x10.dgidb.AttributeR\n" +
+	"attributes\x12F\n" +
+	"\x12interaction_claims\x18\n" +
+	" \x03(\v2\x17.dgidb.InteractionClaimR\x11interactionClaims\x12-\n" +
+	"\bcompound\x18\v \x01(\v2\x11.dgidb.CompoundIDR\bcompound\x12!\n" +
+	"\x04gene\x18\f \x01(\v2\r.dgidb.GeneIDR\x04gene\"N\n" +
+	"\x06GeneID\x12\x18\n" +
+	"\aensembl\x18\x01 \x01(\tR\aensembl\x12\x12\n" +
+	"\x04hgnc\x18\x02 \x01(\tR\x04hgnc\x12\x16\n" +
+	"\x06symbol\x18\x03 \x01(\tR\x06symbol\"\xd0\v\n" +
+	"\n" +
+	"CompoundID\x12\x16\n" +
+	"\x06chembl\x18\x01 \x01(\tR\x06chembl\x12\x18\n" +
+	"\apubchem\x18\x02 \x01(\tR\apubchem\x12\x1a\n" +
+	"\bdrugbank\x18\x03 \x01(\tR\bdrugbank\x12\x14\n" +
+	"\x05chebi\x18\x04 \x01(\tR\x05chebi\x12\x12\n" +
+	"\x04kegg\x18\x05 \x01(\tR\x04kegg\x12 \n" +
+	"\vdrugcentral\x18\x06 \x01(\tR\vdrugcentral\x12\x1c\n" +
+	"\tbindingdb\x18\a \x01(\tR\tbindingdb\x12\x16\n" +
+	"\x06gtopdb\x18\b \x01(\tR\x06gtopdb\x12\x12\n" +
+	"\x04hmdb\x18\t \x01(\tR\x04hmdb\x12\x16\n" +
+	"\x06fdasrs\x18\n" +
+	" \x01(\tR\x06fdasrs\x12\x1a\n" +
+	"\bpharmgkb\x18\v \x01(\tR\bpharmgkb\x12\x12\n" +
+	"\x04zinc\x18\f \x01(\tR\x04zinc\x12\x18\n" +
+	"\acomptox\x18\r \x01(\tR\acomptox\x12\x1c\n" +
+	"\tlipidmaps\x18\x0e \x01(\tR\tlipidmaps\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\x12B\n" +
+	"\vall_sources\x18\x10 \x03(\v2!.dgidb.CompoundID.AllSourcesEntryR\n" +
+	"allSources\x12,\n" +
+	"\x03ids\x18\x11 \x03(\v2\x1a.dgidb.CompoundID.IdsEntryR\x03ids\x12\x1a\n" +
+	"\bdailymed\x18\x12 \x01(\tR\bdailymed\x12&\n" +
+	"\x0eclinicaltrials\x18\x13 \x01(\tR\x0eclinicaltrials\x12\x1e\n" +
+	"\n" +
+	"surechembl\x18\x14 \x01(\tR\n" +
+	"surechembl\x12\x1a\n" +
+	"\binchikey\x18\x15 \x01(\tR\binchikey\x12\x10\n" +
+	"\x03atc\x18\x16 \x03(\tR\x03atc\x12\x19\n" +
+	"\bfull_mwt\x18\x17 \x01(\x01R\afullMwt\x12+\n" +
+	"\x11molecular_formula\x18\x18 \x01(\tR\x10molecularFormula\x12N\n" +
+	"\x0fsource_versions\x18\x19 \x03(\v2%.dgidb.CompoundID.SourceVersionsEntryR\x0esourceVersions\x12\x10\n" +
+	"\x03pdb\x18\x1a \x01(\tR\x03pdb\x12\x1e\n" +
+	"\n" +
+	"emolecules\x18\x1b \x01(\tR\n" +
+	"emolecules\x12\x18\n" +
+	"\amolport\x18\x1c \x01(\tR\amolport\x12\x14\n" +
+	"\x05lincs\x18\x1d \x01(\tR\x05lincs\x12G\n" +
+	"\fconnectivity\x18\x1e \x03(\v2#.dgidb.CompoundID.ConnectivityEntryR\fconnectivity\x12\x1c\n" +
+	"\tfallbacks\x18\x1f \x03(\tR\tfallbacks\x12;\n" +
+	"\bobsolete\x18  \x03(\v2\x1f.dgidb.CompoundID.ObsoleteEntryR\bobsolete\x12)\n" +
+	"\x03raw\x18! \x01(\v2\x16.google.protobuf.ValueR\x04_raw\x12\x16\n" +
+	"\x06rxnorm\x18\" \x01(\tR\x06rxnorm\x1a=\n" +
+	"\x0fAllSourcesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aE\n" +
+	"\bIdsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12#\n" +
+	"\x05value\x18\x02 \x01(\v2\r.dgidb.IDListR\x05value:\x028\x01\x1aA\n" +
+	"\x13SourceVersionsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aN\n" +
+	"\x11ConnectivityEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12#\n" +
+	"\x05value\x18\x02 \x01(\v2\r.dgidb.IDListR\x05value:\x028\x01\x1aJ\n" +
+	"\rObsoleteEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12#\n" +
+	"\x05value\x18\x02 \x01(\v2\r.dgidb.IDListR\x05value:\x028\x01\"\x1a\n" +
+	"\x06IDList\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\tR\x03idsB,Z*github.com/biostream/dgidb-transform/dgidbb\x06proto3"
+
+var (
+	file_dgidb_proto_rawDescOnce sync.Once
+	file_dgidb_proto_rawDescData []byte
+)
+
+func file_dgidb_proto_rawDescGZIP() []byte {
+	file_dgidb_proto_rawDescOnce.Do(func() {
+		file_dgidb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_dgidb_proto_rawDesc), len(file_dgidb_proto_rawDesc)))
+	})
+	return file_dgidb_proto_rawDescData
+}
+
+var file_dgidb_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_dgidb_proto_goTypes = []any{
+	(*Attribute)(nil),        // 0: dgidb.Attribute
+	(*InteractionClaim)(nil), // 1: dgidb.InteractionClaim
+	(*Record)(nil),           // 2: dgidb.Record
+	(*GeneID)(nil),           // 3: dgidb.GeneID
+	(*CompoundID)(nil),       // 4: dgidb.CompoundID
+	(*IDList)(nil),           // 5: dgidb.IDList
+	nil,                      // 6: dgidb.CompoundID.AllSourcesEntry
+	nil,                      // 7: dgidb.CompoundID.IdsEntry
+	nil,                      // 8: dgidb.CompoundID.SourceVersionsEntry
+	nil,                      // 9: dgidb.CompoundID.ConnectivityEntry
+	nil,                      // 10: dgidb.CompoundID.ObsoleteEntry
+	(*structpb.Value)(nil),   // 11: google.protobuf.Value
+}
+var file_dgidb_proto_depIdxs = []int32{
+	0,  // 0: dgidb.InteractionClaim.attributes:type_name -> dgidb.Attribute
+	0,  // 1: dgidb.Record.attributes:type_name -> dgidb.Attribute
+	1,  // 2: dgidb.Record.interaction_claims:type_name -> dgidb.InteractionClaim
+	4,  // 3: dgidb.Record.compound:type_name -> dgidb.CompoundID
+	3,  // 4: dgidb.Record.gene:type_name -> dgidb.GeneID
+	6,  // 5: dgidb.CompoundID.all_sources:type_name -> dgidb.CompoundID.AllSourcesEntry
+	7,  // 6: dgidb.CompoundID.ids:type_name -> dgidb.CompoundID.IdsEntry
+	8,  // 7: dgidb.CompoundID.source_versions:type_name -> dgidb.CompoundID.SourceVersionsEntry
+	9,  // 8: dgidb.CompoundID.connectivity:type_name -> dgidb.CompoundID.ConnectivityEntry
+	10, // 9: dgidb.CompoundID.obsolete:type_name -> dgidb.CompoundID.ObsoleteEntry
+	11, // 10: dgidb.CompoundID.raw:type_name -> google.protobuf.Value
+	5,  // 11: dgidb.CompoundID.IdsEntry.value:type_name -> dgidb.IDList
+	5,  // 12: dgidb.CompoundID.ConnectivityEntry.value:type_name -> dgidb.IDList
+	5,  // 13: dgidb.CompoundID.ObsoleteEntry.value:type_name -> dgidb.IDList
+	14, // [14:14] is the sub-list for method output_type
+	14, // [14:14] is the sub-list for method input_type
+	14, // [14:14] is the sub-list for extension type_name
+	14, // [14:14] is the sub-list for extension extendee
+	0,  // [0:14] is the sub-list for field type_name
+}
+
+func init() { file_dgidb_proto_init() }
+func file_dgidb_proto_init() {
+	if File_dgidb_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_dgidb_proto_rawDesc), len(file_dgidb_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_dgidb_proto_goTypes,
+		DependencyIndexes: file_dgidb_proto_depIdxs,
+		MessageInfos:      file_dgidb_proto_msgTypes,
+	}.Build()
+	File_dgidb_proto = out.File
+	file_dgidb_proto_goTypes = nil
+	file_dgidb_proto_depIdxs = nil
+}
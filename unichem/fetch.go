@@ -0,0 +1,326 @@
+package unichem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Fetcher issues HTTP requests over a shared http.Client, retrying
+// transient failures. It is exported so clients of other services can share
+// its retries and rate limit.
+type Fetcher struct {
+	// HTTPClient sends the requests; nil means http.DefaultClient.
+	HTTPClient *http.Client
+	// Attempts is the maximum number of tries per request; values below 1
+	// mean a single try.
+	Attempts int
+	// Backoff is the delay before the first retry; it doubles on each
+	// subsequent retry.
+	Backoff time.Duration
+	// Timeout bounds each individual request; zero means no limit.
+	Timeout time.Duration
+	// Limiter, when set, is received from before every request so that all
+	// workers sharing this Fetcher stay under a common request rate.
+	Limiter <-chan time.Time
+	// Slots, when set, bounds the requests in flight across the workers
+	// sharing this Fetcher to its capacity.
+	Slots chan struct{}
+	// MaxResponseSize caps the size in bytes of a response body, so a
+	// misbehaving server cannot exhaust memory; zero means
+	// DefaultMaxResponseSize.
+	MaxResponseSize int64
+	// UserAgent, when set, is sent with every request.
+	UserAgent string
+	// Trace, when set, is called after every request, retries included,
+	// with the time it took to send it and read the response.
+	Trace func(method, url string, elapsed time.Duration, err error)
+
+	// requests and retries are updated atomically.
+	requests int64
+	retries  int64
+}
+
+// DefaultMaxResponseSize is the response size cap of a Fetcher that sets
+// none.
+const DefaultMaxResponseSize = 10 * 1024 * 1024
+
+// Requests returns the number of HTTP requests sent so far, retries
+// included.
+func (f *Fetcher) Requests() int64 {
+	return atomic.LoadInt64(&f.requests)
+}
+
+// Retries returns the number of requests that were retries of a failed
+// one.
+func (f *Fetcher) Retries() int64 {
+	return atomic.LoadInt64(&f.retries)
+}
+
+// Fetch issues a request and returns the response body, retrying network
+// errors and 5xx responses with exponential backoff and jitter, and 429
+// responses after the delay their Retry-After header asks for.
+func (f *Fetcher) Fetch(ctx context.Context, method, url string, payload []byte) ([]byte, error) {
+	var body []byte
+	var retry bool
+	var wait time.Duration
+	var err error
+	attempts := f.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := wait
+			if delay == 0 {
+				delay = f.Backoff << uint(attempt-1)
+				delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if f.Slots != nil {
+			select {
+			case f.Slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if f.Limiter != nil {
+			select {
+			case <-f.Limiter:
+			case <-ctx.Done():
+				f.release()
+				return nil, ctx.Err()
+			}
+		}
+		atomic.AddInt64(&f.requests, 1)
+		if attempt > 0 {
+			atomic.AddInt64(&f.retries, 1)
+		}
+		sent := time.Now()
+		body, retry, wait, err = f.fetchOnce(ctx, method, url, payload)
+		f.release()
+		if f.Trace != nil {
+			f.Trace(method, url, time.Since(sent), err)
+		}
+		if err == nil || !retry {
+			return body, err
+		}
+	}
+	return nil, err
+}
+
+// release frees the slot taken for a request, if Slots is set.
+func (f *Fetcher) release() {
+	if f.Slots != nil {
+		<-f.Slots
+	}
+}
+
+// JoinURL appends path to the base URL of a service, or to def when base is
+// empty, so a configured base URL may end in a slash or not.
+func JoinURL(base, def, path string) string {
+	if base == "" {
+		base = def
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// StatusError is returned by Fetch for a response with a status other than
+// 200 OK.
+type StatusError struct {
+	Code int
+	// Body is the start of the response body.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("[STATUS CODE - %d]\t%s", e.Code, e.Body)
+}
+
+// Is makes a 404 response match ErrNotFound and a 429 or 5xx one match
+// ErrTransient.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == http.StatusNotFound
+	case ErrTransient:
+		return e.Code == http.StatusTooManyRequests || e.Code >= 500
+	}
+	return false
+}
+
+// ErrNotFound is matched, with errors.Is, by the error of a request the
+// server answered with 404 Not Found, such as a lookup of a compound
+// UniChem does not know. Trying again will not help.
+var ErrNotFound = errors.New("not found")
+
+// ErrTransient is matched, with errors.Is, by the error of a request that
+// may succeed if tried again later: one that failed on the network or timed
+// out, or was answered with 429 or a 5xx status. Fetch returns it once its
+// own retries are used up.
+var ErrTransient = errors.New("transient failure")
+
+// transientError marks a network failure as ErrTransient while keeping the
+// underlying error available to errors.As.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func (e *transientError) Is(target error) bool {
+	return target == ErrTransient
+}
+
+// fetchOnce performs a single request. The returned bool reports whether
+// the failure is worth retrying and the duration, when not zero, how long
+// the server asked us to wait before doing so.
+func (f *Fetcher) fetchOnce(ctx context.Context, method, url string, payload []byte) ([]byte, bool, time.Duration, error) {
+	parent := ctx
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	client := f.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// The caller cancelling the request is not a transient failure, but the
+	// request timing out is.
+	resp, err := client.Do(req)
+	if err != nil {
+		if parent.Err() == nil {
+			err = &transientError{err}
+		}
+		return nil, true, 0, err
+	}
+	defer resp.Body.Close()
+
+	limit := f.MaxResponseSize
+	if limit <= 0 {
+		limit = DefaultMaxResponseSize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		if parent.Err() == nil {
+			err = &transientError{err}
+		}
+		return nil, true, 0, err
+	}
+	if int64(len(body)) > limit {
+		return nil, false, 0, fmt.Errorf("response larger than %d bytes", limit)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err = &StatusError{Code: resp.StatusCode, Body: bodySnippet(body)}
+		return nil, true, retryAfter(resp.Header.Get("Retry-After")), err
+	}
+	if resp.StatusCode != 200 {
+		err = &StatusError{Code: resp.StatusCode, Body: bodySnippet(body)}
+		return nil, resp.StatusCode >= 500, 0, err
+	}
+
+	// Error pages from proxies and the EBI front end come back as HTML with
+	// a 200 status; reject anything that cannot be JSON.
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !jsonContentType(ct) {
+		return nil, false, 0, fmt.Errorf("unexpected content type %q in response: %q", ct, bodySnippet(body))
+	}
+
+	return body, false, 0, nil
+}
+
+// maxRetryAfter caps how long a Retry-After header can stall a request.
+const maxRetryAfter = 5 * time.Minute
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP
+// date. It returns zero when the header is missing or unparseable, which
+// leaves the delay to the usual backoff.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		wait = time.Until(t)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return wait
+}
+
+// jsonContentType reports whether a Content-Type header allows a JSON body.
+// text/plain is accepted since some services label JSON that way.
+func jsonContentType(ct string) bool {
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" ||
+		mediaType == "text/plain" || strings.HasSuffix(mediaType, "+json")
+}
+
+// bodySnippet returns the start of a response body for error messages.
+func bodySnippet(body []byte) string {
+	const max = 200
+	if len(body) > max {
+		return string(body[:max]) + "..."
+	}
+	return string(body)
+}
+
+// DecodeJSON unmarshals a response body into v. Bodies that are empty or
+// do not match the expected shape are reported with their first bytes.
+func DecodeJSON(body []byte, v interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return fmt.Errorf("empty response body")
+	}
+	err := json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("decoding response: %w: %q", err, bodySnippet(body))
+	}
+	return nil
+}
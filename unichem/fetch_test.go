@@ -0,0 +1,337 @@
+package unichem
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		// requests is how many requests three attempts should make.
+		requests int64
+		want     string
+		is       error
+		// decodeErr is a substring of the error DecodeJSON returns for
+		// the body, if it fails.
+		decodeErr string
+	}{
+		{name: "success", status: http.StatusOK, body: `{"ok": true}`, requests: 1, want: `{"ok": true}`},
+		{name: "not found", status: http.StatusNotFound, body: `{"error": "not found"}`, requests: 1, is: ErrNotFound},
+		{name: "server error", status: http.StatusInternalServerError, body: "oops", requests: 3, is: ErrTransient},
+		{name: "malformed JSON", status: http.StatusOK, body: `{"ok": `, requests: 1, want: `{"ok": `, decodeErr: "decoding response"},
+		{name: "empty body", status: http.StatusOK, body: "", requests: 1, want: "", decodeErr: "empty response body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				serveJSON(tt.status, tt.body)(w, r)
+			}))
+			defer srv.Close()
+
+			f := &Fetcher{HTTPClient: srv.Client(), Attempts: 3, Backoff: time.Millisecond}
+			body, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+			if tt.is != nil {
+				if !errors.Is(err, tt.is) {
+					t.Errorf("error = %v, want %v", err, tt.is)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+			if requests != tt.requests || f.Requests() != tt.requests {
+				t.Errorf("sent %d requests, counted %d, want %d", requests, f.Requests(), tt.requests)
+			}
+			if tt.is != nil {
+				return
+			}
+
+			var v map[string]interface{}
+			err = DecodeJSON(body, &v)
+			if tt.decodeErr == "" && err != nil {
+				t.Errorf("DecodeJSON: %v", err)
+			}
+			if tt.decodeErr != "" && (err == nil || !strings.Contains(err.Error(), tt.decodeErr)) {
+				t.Errorf("DecodeJSON error = %v, want one containing %q", err, tt.decodeErr)
+			}
+		})
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		base, def, path, want string
+	}{
+		{"", "https://www.ebi.ac.uk/unichem", "/api/v1/compounds", "https://www.ebi.ac.uk/unichem/api/v1/compounds"},
+		{"http://localhost:8080", DefaultURL, "/api/v1/compounds", "http://localhost:8080/api/v1/compounds"},
+		{"http://localhost:8080/unichem/", DefaultURL, "/rest/src_ids/", "http://localhost:8080/unichem/rest/src_ids/"},
+		{"http://localhost:8080/graphql/", "https://dgidb.org/api/graphql", "", "http://localhost:8080/graphql"},
+	}
+	for _, tt := range tests {
+		if got := JoinURL(tt.base, tt.def, tt.path); got != tt.want {
+			t.Errorf("JoinURL(%q, %q, %q) = %q, want %q", tt.base, tt.def, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFetchRetriesTransientFailures(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= 2 {
+			serveJSON(http.StatusServiceUnavailable, `{"error": "busy"}`)(w, r)
+			return
+		}
+		serveJSON(http.StatusOK, `{"ok": true}`)(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{HTTPClient: srv.Client(), Attempts: 4, Backoff: 10 * time.Millisecond}
+	start := time.Now()
+	body, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"ok": true}` {
+		t.Errorf("body = %q", body)
+	}
+	if requests != 3 || f.Retries() != 2 {
+		t.Errorf("sent %d requests with %d retries, want 3 with 2", requests, f.Retries())
+	}
+	// The backoff doubles from 10ms, with up to half of each delay taken
+	// off by jitter.
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("retries took %s, want a backoff of at least 15ms", elapsed)
+	}
+}
+
+func TestFetchGivesUp(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		serveJSON(http.StatusBadGateway, "")(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{HTTPClient: srv.Client(), Attempts: 2, Backoff: time.Millisecond}
+	_, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+	status := &StatusError{}
+	if !errors.As(err, &status) || status.Code != http.StatusBadGateway {
+		t.Errorf("error = %v, want a 502 StatusError", err)
+	}
+	if requests != 2 {
+		t.Errorf("sent %d requests, want 2", requests)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		serveJSON(http.StatusOK, "{}")(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{HTTPClient: srv.Client(), Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	_, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTransient) {
+		t.Errorf("error = %v, want a transient deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("request took %s, want it cut short at the 20ms timeout", elapsed)
+	}
+}
+
+func TestFetchUserAgent(t *testing.T) {
+	var agent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.Header.Get("User-Agent")
+		serveJSON(http.StatusOK, "{}")(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{HTTPClient: srv.Client(), UserAgent: "dgidb-transform/test"}
+	if _, err := f.Fetch(context.Background(), "GET", srv.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if agent != "dgidb-transform/test" {
+		t.Errorf("User-Agent = %q, want dgidb-transform/test", agent)
+	}
+}
+
+func TestUnexpectedResponses(t *testing.T) {
+	html := "<!DOCTYPE html><html><head><title>EMBL-EBI: Service unavailable</title></head><body>" + strings.Repeat("x", 300) + "</body></html>"
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		// want are substrings of the error of a legacy lookup.
+		want []string
+	}{
+		{name: "html page", status: http.StatusOK, contentType: "text/html; charset=utf-8", body: html, want: []string{`unexpected content type "text/html; charset=utf-8"`, "EMBL-EBI: Service unavailable", "..."}},
+		{name: "unlabelled html page", status: http.StatusOK, body: html, want: []string{"decoding response", "<!DOCTYPE html>"}},
+		{name: "html error status", status: http.StatusBadGateway, contentType: "text/html", body: html, want: []string{"502", "EMBL-EBI: Service unavailable"}},
+		{name: "object", status: http.StatusOK, contentType: "application/json", body: `{"error": "Invalid source"}`, want: []string{"decoding response", `{\"error\": \"Invalid source\"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				} else {
+					// Stop net/http from sniffing one.
+					w.Header()["Content-Type"] = nil
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := testClient(srv, "legacy").GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err == nil {
+				t.Fatal("lookup succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error = %v, want it to contain %q", err, want)
+				}
+			}
+			if strings.Contains(err.Error(), strings.Repeat("x", 201)) {
+				t.Errorf("error quotes more than the first 200 bytes of the body: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONNull(t *testing.T) {
+	var v []map[string]string
+	if err := DecodeJSON([]byte("null"), &v); err != nil || v != nil {
+		t.Errorf("DecodeJSON(null) = %v, %v; want no mappings", v, err)
+	}
+}
+
+func TestFetchRetryAfter(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			serveJSON(http.StatusTooManyRequests, `{"error": "slow down"}`)(w, r)
+			return
+		}
+		serveJSON(http.StatusOK, `{"ok": true}`)(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{HTTPClient: srv.Client(), Attempts: 3, Backoff: time.Millisecond}
+	start := time.Now()
+	body, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"ok": true}` || requests != 2 {
+		t.Errorf("body = %q after %d requests, want the second to succeed", body, requests)
+	}
+	// The 1ms backoff would retry at once; Retry-After asks for a second.
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s Retry-After", elapsed)
+	}
+}
+
+func TestFetchTooManyRequestsGivesUp(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		serveJSON(http.StatusTooManyRequests, "")(w, r)
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{HTTPClient: srv.Client(), Attempts: 2, Backoff: time.Millisecond}
+	_, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+	status := &StatusError{}
+	if !errors.As(err, &status) || status.Code != http.StatusTooManyRequests || !errors.Is(err, ErrTransient) {
+		t.Errorf("error = %v, want a transient 429 StatusError", err)
+	}
+	if requests != 2 {
+		t.Errorf("sent %d requests, want 2", requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{" 3 ", 3 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{"86400", maxRetryAfter},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+	// An HTTP date is counted from now, to the second.
+	header := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := retryAfter(header); got < 58*time.Second || got > time.Minute {
+		t.Errorf("retryAfter(%q) = %s, want about a minute", header, got)
+	}
+}
+
+func TestFetchMaxResponseSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int64
+		size  int
+		err   string
+	}{
+		{name: "at the cap", limit: 100, size: 100},
+		{name: "over the cap", limit: 100, size: 101, err: "response larger than 100 bytes"},
+		{name: "default cap", size: DefaultMaxResponseSize},
+		{name: "over the default cap", size: DefaultMaxResponseSize + 1, err: "response larger than 10485760 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int64
+			body := strings.Repeat("x", tt.size)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				w.Write([]byte(body))
+			}))
+			defer srv.Close()
+
+			f := &Fetcher{HTTPClient: srv.Client(), Attempts: 3, Backoff: time.Millisecond, MaxResponseSize: tt.limit}
+			got, err := f.Fetch(context.Background(), "GET", srv.URL, nil)
+			if tt.err == "" {
+				if err != nil || len(got) != tt.size {
+					t.Errorf("Fetch = %d bytes, %v, want the %d byte body", len(got), err, tt.size)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) || got != nil {
+				t.Errorf("Fetch = %d bytes, %v, want no body and an error containing %q", len(got), err, tt.err)
+			}
+			// The same server would only send the same body again.
+			if requests != 1 {
+				t.Errorf("sent %d requests, want 1", requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,916 @@
+// Package unichem resolves compounds to the IDs other databases use for
+// them through the EBI UniChem web services.
+package unichem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultURL is the public EBI UniChem endpoint.
+const DefaultURL = "https://www.ebi.ac.uk/unichem"
+
+// CompoundID represents a subset of mappings from:
+// https://www.ebi.ac.uk/unichem/rest/src_compound_id/{compound_id}/{source_id}
+//
+// Sources described here:
+// https://www.ebi.ac.uk/unichem/ucquery/listSources
+type CompoundID struct {
+	// source_id 1
+	ChEMBL string `json:"chembl,omitempty"`
+	// source_id 22
+	PubChem string `json:"pubchem,omitempty"`
+	// source_id 2
+	DrugBank string `json:"drugbank,omitempty"`
+	// source_id 7
+	ChEBI string `json:"chebi,omitempty"`
+	// source_id 6
+	KEGG string `json:"kegg,omitempty"`
+	// source_id 34
+	DrugCentral string `json:"drugcentral,omitempty"`
+	// source_id 31
+	BindingDB string `json:"bindingdb,omitempty"`
+	// source_id 4
+	GtoPdb string `json:"gtopdb,omitempty"`
+	// source_id 18
+	HMDB string `json:"hmdb,omitempty"`
+	// source_id 14
+	UNII string `json:"fdasrs,omitempty"`
+	// source_id 17
+	PharmGKB string `json:"pharmgkb,omitempty"`
+	// source_id 9
+	ZINC string `json:"zinc,omitempty"`
+	// source_id 32
+	CompTox string `json:"comptox,omitempty"`
+	// source_id 33
+	LipidMaps string `json:"lipidmaps,omitempty"`
+	// source_id 45
+	DailyMed string `json:"dailymed,omitempty"`
+	// source_id 46
+	ClinicalTrials string `json:"clinicaltrials,omitempty"`
+	// source_id 15
+	SureChEMBL string `json:"surechembl,omitempty"`
+	// source_id 3. A compound often has several ligand codes; the
+	// others are listed in IDs.
+	PDB string `json:"pdb,omitempty"`
+	// source_id 10
+	EMolecules string `json:"emolecules,omitempty"`
+	// source_id 28
+	MolPort string `json:"molport,omitempty"`
+	// source_id 25
+	LINCS string `json:"lincs,omitempty"`
+	// InChIKey is the standard InChIKey of the compound's structure. Only
+	// set when Client.WithStructure is.
+	InChIKey string `json:"inchikey,omitempty"`
+	// ATC lists the compound's ATC classification codes. Client does not
+	// set it; callers fill it from ChEMBL.
+	ATC []string `json:"atc,omitempty"`
+	// RxNorm is the compound's RxNorm CUI; when it has several the rest are
+	// listed in IDs. Client does not set it; callers fill it from RxNorm.
+	RxNorm string `json:"rxnorm,omitempty"`
+	// Fallbacks names the fields that callers filled from a service other
+	// than UniChem.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	// Connectivity lists the IDs of structurally related compounds found
+	// by GetConnectivity, keyed by source name. It is filled by callers.
+	Connectivity map[string][]string `json:"connectivity,omitempty"`
+	// FullMWT and MolecularFormula are the molecular weight and formula
+	// of the compound, also filled by callers from ChEMBL.
+	FullMWT          float64 `json:"full_mwt,omitempty"`
+	MolecularFormula string  `json:"molecular_formula,omitempty"`
+	// AllSources holds every mapping UniChem returned, keyed by source name,
+	// including sources without a typed field. Only set when
+	// Client.AllSources is.
+	AllSources map[string]string `json:"all_sources,omitempty"`
+	// IDs lists, in sorted order, every ID of a source that UniChem maps the
+	// compound to more than one of, keyed by the field the first one fills.
+	IDs map[string][]string `json:"ids,omitempty"`
+	// Obsolete lists the IDs UniChem once linked the compound to but has
+	// since retired, keyed like IDs. Only set when Client.IncludeObsolete
+	// is.
+	Obsolete map[string][]string `json:"obsolete,omitempty"`
+	// SourceVersions holds the UniChem release of each source the compound
+	// was mapped to, keyed by the field its ID fills. Only set when
+	// Client.WithSourceMeta is.
+	SourceVersions map[string]string `json:"source_versions,omitempty"`
+	// Raw is the UniChem response the mappings were read from, verbatim.
+	// Only set when Client.KeepRaw is.
+	Raw json.RawMessage `json:"_raw,omitempty"`
+	// Error is set when the UniChem lookup failed, so a failed mapping is not
+	// mistaken for a compound with no external IDs.
+	Error string `json:"error,omitempty"`
+}
+
+// KnownSources maps the UniChem src_ids handled by GetCompoundIDs to the
+// CompoundID JSON field they populate.
+var KnownSources = map[string]string{
+	"2":  "drugbank",
+	"3":  "pdb",
+	"4":  "gtopdb",
+	"6":  "kegg",
+	"7":  "chebi",
+	"9":  "zinc",
+	"10": "emolecules",
+	"14": "fdasrs",
+	"15": "surechembl",
+	"17": "pharmgkb",
+	"18": "hmdb",
+	"22": "pubchem",
+	"25": "lincs",
+	"28": "molport",
+	"31": "bindingdb",
+	"32": "comptox",
+	"33": "lipidmaps",
+	"34": "drugcentral",
+	"45": "dailymed",
+	"46": "clinicaltrials",
+}
+
+// ParseSources parses a comma separated list of UniChem src_ids. An empty
+// list selects every known source.
+func ParseSources(list string) (map[string]bool, error) {
+	sources := map[string]bool{}
+	if strings.TrimSpace(list) == "" {
+		for id := range KnownSources {
+			sources[id] = true
+		}
+		return sources, nil
+	}
+
+	for _, id := range strings.Split(list, ",") {
+		id = strings.TrimSpace(id)
+		if _, ok := KnownSources[id]; !ok {
+			known := []string{}
+			for k := range KnownSources {
+				known = append(known, k)
+			}
+			return nil, fmt.Errorf("unknown UniChem src_id %q; known sources are %s", id, strings.Join(SortSourceIDs(known), ","))
+		}
+		sources[id] = true
+	}
+	return sources, nil
+}
+
+// SortSourceIDs sorts src_ids numerically in place and returns them.
+func SortSourceIDs(ids []string) []string {
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.Atoi(ids[i])
+		b, _ := strconv.Atoi(ids[j])
+		return a < b
+	})
+	return ids
+}
+
+// Client issues requests against the UniChem REST API. Use NewClient for
+// one with working defaults.
+type Client struct {
+	*Fetcher
+	// API selects the UniChem interface: "v1" for api/v1/compounds or
+	// "legacy" for the older rest/src_compound_id endpoint.
+	API string
+	// BaseURL is the root of the UniChem web services; empty means
+	// DefaultURL.
+	BaseURL string
+	// Sources selects the src_ids whose CompoundID fields are filled; nil
+	// selects every one of KnownSources.
+	Sources map[string]bool
+	// AllSources fills CompoundID.AllSources with every mapping returned.
+	AllSources bool
+	// WithStructure fills CompoundID.InChIKey. It is free with the v1 API
+	// and costs one more request per compound with the legacy one.
+	WithStructure bool
+	// WithSourceMeta fills CompoundID.SourceVersions from the source list
+	// of the v1 API, which is fetched once.
+	WithSourceMeta bool
+	// IncludeObsolete looks compounds up with the legacy
+	// src_compound_id_all endpoint, which also returns retired mappings,
+	// and fills CompoundID.Obsolete with them. The legacy API uses that
+	// endpoint anyway when more than one source is selected, but then
+	// drops the retired mappings. It is ignored by the v1 API, which only
+	// returns current mappings.
+	IncludeObsolete bool
+	// KeepRaw fills CompoundID.Raw with the response the mappings came
+	// from, for auditing.
+	KeepRaw bool
+	// EmptyRetries is how many times a lookup is repeated while UniChem
+	// answers with fewer than MinMappings mappings, which it sometimes does
+	// transiently for compounds it does map. Zero trusts the first answer.
+	EmptyRetries int
+	// MinMappings is the number of mappings below which a response is
+	// retried; values below 1 mean 1, so only empty responses are.
+	MinMappings int
+	// Logger receives warnings about malformed IDs in UniChem responses;
+	// nil discards them.
+	Logger *slog.Logger
+
+	namesOnce sync.Once
+	names     map[string]string
+	namesErr  error
+
+	releasesOnce sync.Once
+	releases     map[string]string
+	releasesErr  error
+}
+
+// NewClient returns a Client for the public UniChem v1 API that resolves
+// every known source, trying each request once.
+func NewClient() *Client {
+	return &Client{Fetcher: &Fetcher{}, API: "v1", BaseURL: DefaultURL}
+}
+
+// sources returns the selected src_ids.
+func (c *Client) sources() map[string]bool {
+	if c.Sources != nil {
+		return c.Sources
+	}
+	sources, _ := ParseSources("")
+	return sources
+}
+
+// lookupAll reports whether legacy lookups use the src_compound_id_all
+// endpoint rather than src_compound_id.
+func (c *Client) lookupAll() bool {
+	return c.IncludeObsolete || len(c.sources()) > 1
+}
+
+// GetCompoundIDs resolves a ChEMBL ID to the external compound IDs tracked
+// by CompoundID. Only the src_ids selected by Sources are populated.
+func (c *Client) GetCompoundIDs(ctx context.Context, chemblID string) (CompoundID, error) {
+	return c.GetCompoundIDsBySource(ctx, chemblID, "1")
+}
+
+// GetCompoundIDsBySource is like GetCompoundIDs but looks up id in the
+// UniChem source srcID, e.g. "22" for a PubChem CID. The ChEMBL field is
+// always filled when UniChem links the compound to ChEMBL.
+func (c *Client) GetCompoundIDsBySource(ctx context.Context, id, srcID string) (CompoundID, error) {
+	id, err := queryID(id, srcID)
+	if err != nil {
+		return CompoundID{}, err
+	}
+
+	var mappings []map[string]string
+	var inchikey string
+	var raw []byte
+	for attempt := 0; ; attempt++ {
+		if c.API == "legacy" {
+			urlTmpl := "/rest/src_compound_id/%s/%s"
+			if c.lookupAll() {
+				urlTmpl = "/rest/src_compound_id_all/%s/%s"
+			}
+			mappings, raw, err = c.getRaw(ctx, JoinURL(c.BaseURL, DefaultURL, fmt.Sprintf(urlTmpl, url.PathEscape(id), srcID)))
+			if err == nil && c.WithStructure {
+				inchikey, err = c.structure(ctx, id, srcID)
+			}
+		} else {
+			mappings, inchikey, raw, err = c.compoundSources(ctx, id, srcID)
+		}
+		if err != nil || len(mappings) >= max(c.MinMappings, 1) || attempt >= c.EmptyRetries {
+			break
+		}
+		if c.Logger != nil {
+			c.Logger.Debug("retrying sparse UniChem response", "id", id, "mappings", len(mappings), "attempt", attempt+1)
+		}
+		select {
+		case <-time.After(c.Backoff << uint(attempt)):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("resolving %s: %w", id, err)
+	}
+	return c.compound(ctx, id, srcID, mappings, inchikey, raw, err)
+}
+
+// queryID checks that id can be looked up in the UniChem source srcID,
+// normalizing it when it is a ChEMBL ID.
+func queryID(id, srcID string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("no compound ID to resolve")
+	}
+	if srcID == "1" {
+		return NormalizeChEMBLID(id)
+	}
+	return id, nil
+}
+
+// compound builds the CompoundID of id, an ID of the UniChem source srcID,
+// from the mappings, InChIKey and response body its lookup returned. err is
+// the error of that lookup; it is returned with the compound, which still
+// identifies id.
+func (c *Client) compound(ctx context.Context, id, srcID string, mappings []map[string]string, inchikey string, raw []byte, err error) (CompoundID, error) {
+	// UniChem does not list the queried ID among its mappings, so seed it;
+	// a failed lookup then still identifies the compound.
+	respMap := []map[string]string{{"src_id": srcID, "src_compound_id": id}}
+	// Retired mappings never fill the fields, whether or not they were
+	// asked for.
+	mappings, obsolete := splitObsolete(mappings)
+	respMap = append(respMap, mappings...)
+
+	if c.AllSources {
+		nameErr := c.nameSources(ctx, respMap)
+		if err == nil {
+			err = nameErr
+		}
+	}
+	c.normalizeIDs(respMap)
+	compound := compoundFromMappings(respMap, c.sources(), c.AllSources)
+	if c.WithStructure {
+		compound.InChIKey = inchikey
+	}
+	if c.IncludeObsolete {
+		c.normalizeIDs(obsolete)
+		compound.Obsolete = obsoleteIDs(obsolete, c.sources())
+	}
+	if c.KeepRaw {
+		compound.Raw = raw
+	}
+	if c.WithSourceMeta {
+		versions, metaErr := c.sourceVersions(ctx, respMap)
+		if err == nil {
+			err = metaErr
+		}
+		compound.SourceVersions = versions
+	}
+	return compound, err
+}
+
+var chemblIDPattern = regexp.MustCompile(`^CHEMBL\d+$`)
+
+// NormalizeChEMBLID trims and upper-cases id, adding the CHEMBL prefix to
+// bare digits, and rejects anything that is still not a ChEMBL ID.
+func NormalizeChEMBLID(id string) (string, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(id))
+	if _, err := strconv.ParseUint(normalized, 10, 64); err == nil {
+		normalized = "CHEMBL" + normalized
+	}
+	if !chemblIDPattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid ChEMBL ID %q", id)
+	}
+	return normalized, nil
+}
+
+var drugBankIDPattern = regexp.MustCompile(`^DB\d{5}$`)
+
+// NormalizeDrugBankID trims and upper-cases id, zero-pads its number to
+// five digits and adds the DB prefix to bare digits, and rejects anything
+// that is still not a DrugBank ID.
+func NormalizeDrugBankID(id string) (string, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(id))
+	if n, err := strconv.ParseUint(strings.TrimPrefix(normalized, "DB"), 10, 64); err == nil {
+		normalized = fmt.Sprintf("DB%05d", n)
+	}
+	if !drugBankIDPattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid DrugBank ID %q", id)
+	}
+	return normalized, nil
+}
+
+var chebiIDPattern = regexp.MustCompile(`^CHEBI:[1-9]\d*$`)
+
+// NormalizeChEBIID trims and upper-cases id and adds the CHEBI: prefix to
+// bare digits, and rejects anything that is still not a ChEBI ID.
+func NormalizeChEBIID(id string) (string, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(id))
+	if n, err := strconv.ParseUint(strings.TrimPrefix(normalized, "CHEBI:"), 10, 64); err == nil {
+		normalized = fmt.Sprintf("CHEBI:%d", n)
+	}
+	if !chebiIDPattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid ChEBI ID %q", id)
+	}
+	return normalized, nil
+}
+
+// idNormalizers canonicalize the IDs of the sources UniChem returns in more
+// than one form, keyed by src_id.
+var idNormalizers = map[string]func(string) (string, error){
+	"2": NormalizeDrugBankID,
+	"7": NormalizeChEBIID,
+}
+
+// normalizeIDs rewrites the DrugBank and ChEBI IDs in respMap to their
+// canonical form. IDs that cannot be normalized are kept as returned and
+// logged.
+func (c *Client) normalizeIDs(respMap []map[string]string) {
+	for _, v := range respMap {
+		normalize, ok := idNormalizers[v["src_id"]]
+		if !ok {
+			continue
+		}
+		id, err := normalize(v["src_compound_id"])
+		if err != nil {
+			if c.Logger != nil {
+				c.Logger.Warn("unexpected ID from UniChem", "src_id", v["src_id"], "err", err)
+			}
+			continue
+		}
+		v["src_compound_id"] = id
+	}
+}
+
+// splitObsolete separates the mappings of a src_compound_id_all response
+// that UniChem has retired, whose assignment is not 1, from the current
+// ones.
+func splitObsolete(mappings []map[string]string) (current, obsolete []map[string]string) {
+	for _, v := range mappings {
+		assignment := v["assignment"]
+		if assignment == "" {
+			assignment = v["Assignment"]
+		}
+		if assignment == "" || assignment == "1" {
+			current = append(current, v)
+		} else {
+			obsolete = append(obsolete, v)
+		}
+	}
+	return current, obsolete
+}
+
+// obsoleteIDs lists the obsolete IDs of each selected source in sorted
+// order, keyed like CompoundID.IDs.
+func obsoleteIDs(obsolete []map[string]string, sources map[string]bool) map[string][]string {
+	ids := map[string][]string{}
+	srcIDs := map[string]string{}
+	for _, v := range obsolete {
+		src := v["src_id"]
+		if src != "1" && !sources[src] {
+			continue
+		}
+		field := KnownSources[src]
+		if src == "1" {
+			field = "chembl"
+		}
+		ids[field] = append(ids[field], v["src_compound_id"])
+		srcIDs[field] = src
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	for field, list := range ids {
+		sortIDs(srcIDs[field], list)
+		ids[field] = slices.Compact(list)
+	}
+	return ids
+}
+
+// GetCompoundIDsByInChIKey resolves a structure's standard InChIKey to the
+// compound IDs of every source UniChem links to it.
+func (c *Client) GetCompoundIDsByInChIKey(ctx context.Context, inchikey string) (CompoundID, error) {
+	urlTmpl := "/rest/inchikey/%s"
+	respMap, raw, err := c.getRaw(ctx, JoinURL(c.BaseURL, DefaultURL, fmt.Sprintf(urlTmpl, url.PathEscape(inchikey))))
+	if err != nil {
+		return CompoundID{}, fmt.Errorf("resolving %s: %w", inchikey, err)
+	}
+	if c.AllSources {
+		err = c.nameSources(ctx, respMap)
+		if err != nil {
+			return CompoundID{}, fmt.Errorf("resolving %s: %w", inchikey, err)
+		}
+	}
+	c.normalizeIDs(respMap)
+	compound := compoundFromMappings(respMap, c.sources(), c.AllSources)
+	if c.WithStructure {
+		compound.InChIKey = inchikey
+	}
+	if c.KeepRaw {
+		compound.Raw = raw
+	}
+	if c.WithSourceMeta {
+		compound.SourceVersions, err = c.sourceVersions(ctx, respMap)
+		if err != nil {
+			return CompoundID{}, fmt.Errorf("resolving %s: %w", inchikey, err)
+		}
+	}
+	return compound, nil
+}
+
+// GetConnectivity runs a v1 connectivity search for the compounds that share
+// the InChIKey connectivity layer of inchikey, such as its stereoisomers
+// and isotopologues, and returns their IDs in sorted order grouped by
+// source name. It uses the v1 API whatever Client.API is.
+func (c *Client) GetConnectivity(ctx context.Context, inchikey string) (map[string][]string, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":             "inchikey",
+		"compound":         inchikey,
+		"searchComponents": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.Fetch(ctx, "POST", JoinURL(c.BaseURL, DefaultURL, "/api/v1/connectivity"), payload)
+	if err != nil {
+		return nil, fmt.Errorf("searching connectivity of %s: %w", inchikey, err)
+	}
+
+	resp := v1Connectivity{}
+	err = DecodeJSON(body, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("searching connectivity of %s: %w", inchikey, err)
+	}
+
+	related := map[string][]string{}
+	for _, src := range resp.Sources {
+		name := src.ShortName
+		if name == "" {
+			name = strconv.Itoa(src.ID)
+		}
+		related[name] = append(related[name], src.CompoundID)
+	}
+	for name, ids := range related {
+		sort.Strings(ids)
+		related[name] = slices.Compact(ids)
+	}
+	return related, nil
+}
+
+// structure looks up the standard InChIKey of a compound with the legacy
+// rest/structure endpoint. Compounds without a structure yield an empty
+// key.
+func (c *Client) structure(ctx context.Context, id, srcID string) (string, error) {
+	urlTmpl := "/rest/structure/%s/%s"
+	structures, err := c.get(ctx, JoinURL(c.BaseURL, DefaultURL, fmt.Sprintf(urlTmpl, url.PathEscape(id), srcID)))
+	if err != nil {
+		return "", fmt.Errorf("fetching structure: %w", err)
+	}
+	if len(structures) == 0 {
+		return "", nil
+	}
+	return structures[0]["standardinchikey"], nil
+}
+
+// nameSources sets src_name on mappings that lack one. The v1 API names its
+// sources inline; the legacy endpoints do not, so their names are fetched
+// once from rest/sources.
+func (c *Client) nameSources(ctx context.Context, respMap []map[string]string) error {
+	inline := map[string]string{}
+	missing := false
+	for _, v := range respMap {
+		if v["src_name"] != "" {
+			inline[v["src_id"]] = v["src_name"]
+		}
+	}
+	for _, v := range respMap {
+		if v["src_name"] == "" {
+			v["src_name"] = inline[v["src_id"]]
+			missing = missing || v["src_name"] == ""
+		}
+	}
+	if !missing || c.API != "legacy" {
+		return nil
+	}
+
+	c.namesOnce.Do(func() {
+		c.names, c.namesErr = c.sourceNames(ctx)
+	})
+	if c.namesErr != nil {
+		return fmt.Errorf("listing UniChem sources: %w", c.namesErr)
+	}
+	for _, v := range respMap {
+		if v["src_name"] == "" {
+			v["src_name"] = c.names[v["src_id"]]
+		}
+	}
+	return nil
+}
+
+// sourceVersions returns the release of each selected source in respMap,
+// keyed like CompoundID.IDs. Sources UniChem lists no release for are left
+// out.
+func (c *Client) sourceVersions(ctx context.Context, respMap []map[string]string) (map[string]string, error) {
+	c.releasesOnce.Do(func() {
+		c.releases, c.releasesErr = c.sourceReleases(ctx)
+	})
+	if c.releasesErr != nil {
+		return nil, fmt.Errorf("listing UniChem sources: %w", c.releasesErr)
+	}
+
+	sources := c.sources()
+	versions := map[string]string{}
+	for _, v := range respMap {
+		src := v["src_id"]
+		if src != "1" && !sources[src] {
+			continue
+		}
+		field := KnownSources[src]
+		if src == "1" {
+			field = "chembl"
+		}
+		if release := c.releases[src]; release != "" {
+			versions[field] = release
+		}
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	return versions, nil
+}
+
+// v1Sources is the subset of an api/v1/sources response that is used.
+// srcReleaseNumber is not consistently a number or a string.
+type v1Sources struct {
+	Sources []struct {
+		SourceID      int             `json:"sourceID"`
+		ReleaseNumber json.RawMessage `json:"srcReleaseNumber"`
+		ReleaseDate   string          `json:"srcReleaseDate"`
+	} `json:"sources"`
+}
+
+// sourceReleases maps every UniChem src_id to the release of the source it
+// holds, or its release date when it has no release number.
+func (c *Client) sourceReleases(ctx context.Context) (map[string]string, error) {
+	body, err := c.Fetch(ctx, "GET", JoinURL(c.BaseURL, DefaultURL, "/api/v1/sources/"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := v1Sources{}
+	err = DecodeJSON(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := map[string]string{}
+	for _, src := range resp.Sources {
+		release := ""
+		if err := json.Unmarshal(src.ReleaseNumber, &release); err != nil {
+			number := json.Number("")
+			if json.Unmarshal(src.ReleaseNumber, &number) == nil {
+				release = number.String()
+			}
+		}
+		if release == "" {
+			release = src.ReleaseDate
+		}
+		releases[strconv.Itoa(src.SourceID)] = release
+	}
+	return releases, nil
+}
+
+// sourceNames maps every UniChem src_id to its name.
+func (c *Client) sourceNames(ctx context.Context) (map[string]string, error) {
+	srcIDs, err := c.get(ctx, JoinURL(c.BaseURL, DefaultURL, "/rest/src_ids/"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, src := range srcIDs {
+		info, err := c.get(ctx, JoinURL(c.BaseURL, DefaultURL, fmt.Sprintf("/rest/sources/%s", url.PathEscape(src["src_id"]))))
+		if err != nil {
+			return nil, err
+		}
+		if len(info) > 0 {
+			names[src["src_id"]] = info[0]["name"]
+		}
+	}
+	return names, nil
+}
+
+// numericSources are the src_ids whose IDs are numbers after a fixed
+// prefix, mapped to that prefix. They sort by value rather than as text so
+// that the first of them is the lowest, CHEBI:99 before CHEBI:100.
+var numericSources = map[string]string{"4": "", "7": "CHEBI:", "10": "", "22": "", "31": "", "34": ""}
+
+// lessID reports whether the ID a of source srcID sorts before b. The IDs
+// of numericSources compare by value, and anything else, including ties
+// such as 074 and 74, as text.
+func lessID(srcID, a, b string) bool {
+	if prefix, ok := numericSources[srcID]; ok {
+		x, xerr := strconv.ParseUint(strings.TrimPrefix(a, prefix), 10, 64)
+		y, yerr := strconv.ParseUint(strings.TrimPrefix(b, prefix), 10, 64)
+		if xerr == nil && yerr == nil && x != y {
+			return x < y
+		}
+	}
+	return a < b
+}
+
+// sortIDs sorts the IDs of source srcID in place, numerically for
+// numericSources.
+func sortIDs(srcID string, ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		return lessID(srcID, ids[i], ids[j])
+	})
+}
+
+// compoundFromMappings builds a CompoundID from UniChem src_id mappings,
+// keeping only the src_ids in sources. Where a source maps to several IDs
+// the first one in sorted order, the lowest for numericSources, fills its
+// field and all of them are listed in IDs. With all set every mapping is
+// also recorded in AllSources, keyed by src_name or, failing that, src_id.
+func compoundFromMappings(respMap []map[string]string, sources map[string]bool, all bool) CompoundID {
+	compound := CompoundID{}
+	if all {
+		compound.AllSources = map[string]string{}
+	}
+
+	sort.Slice(respMap, func(i, j int) bool {
+		a, b := respMap[i], respMap[j]
+		if a["src_id"] != b["src_id"] {
+			return a["src_id"] < b["src_id"]
+		}
+		return lessID(a["src_id"], a["src_compound_id"], b["src_compound_id"])
+	})
+
+	ids := map[string][]string{}
+	for _, v := range respMap {
+		if all {
+			name := v["src_name"]
+			if name == "" {
+				name = v["src_id"]
+			}
+			if _, ok := compound.AllSources[name]; !ok {
+				compound.AllSources[name] = v["src_compound_id"]
+			}
+		}
+		if v["src_id"] != "1" && !sources[v["src_id"]] {
+			continue
+		}
+		if seen := ids[v["src_id"]]; len(seen) > 0 {
+			if seen[len(seen)-1] != v["src_compound_id"] {
+				ids[v["src_id"]] = append(seen, v["src_compound_id"])
+			}
+			continue
+		}
+		ids[v["src_id"]] = []string{v["src_compound_id"]}
+
+		switch v["src_id"] {
+		case "1":
+			compound.ChEMBL = v["src_compound_id"]
+		case "2":
+			compound.DrugBank = v["src_compound_id"]
+		case "3":
+			compound.PDB = v["src_compound_id"]
+		case "4":
+			compound.GtoPdb = v["src_compound_id"]
+		case "6":
+			compound.KEGG = v["src_compound_id"]
+		case "7":
+			compound.ChEBI = v["src_compound_id"]
+		case "9":
+			compound.ZINC = v["src_compound_id"]
+		case "10":
+			compound.EMolecules = v["src_compound_id"]
+		case "14":
+			compound.UNII = v["src_compound_id"]
+		case "15":
+			compound.SureChEMBL = v["src_compound_id"]
+		case "17":
+			compound.PharmGKB = v["src_compound_id"]
+		case "18":
+			compound.HMDB = v["src_compound_id"]
+		case "22":
+			compound.PubChem = v["src_compound_id"]
+		case "25":
+			compound.LINCS = v["src_compound_id"]
+		case "28":
+			compound.MolPort = v["src_compound_id"]
+		case "31":
+			compound.BindingDB = v["src_compound_id"]
+		case "32":
+			compound.CompTox = v["src_compound_id"]
+		case "33":
+			compound.LipidMaps = v["src_compound_id"]
+		case "34":
+			compound.DrugCentral = v["src_compound_id"]
+		case "45":
+			compound.DailyMed = v["src_compound_id"]
+		case "46":
+			compound.ClinicalTrials = v["src_compound_id"]
+		}
+	}
+
+	for src, list := range ids {
+		if len(list) < 2 {
+			continue
+		}
+		if compound.IDs == nil {
+			compound.IDs = map[string][]string{}
+		}
+		field := KnownSources[src]
+		if src == "1" {
+			field = "chembl"
+		}
+		compound.IDs[field] = list
+	}
+
+	return compound
+}
+
+// v1Compounds is the subset of an api/v1/compounds response that is used.
+type v1Compounds struct {
+	Compounds []v1Compound `json:"compounds"`
+}
+
+type v1Compound struct {
+	StandardInChIKey string `json:"standardInchiKey"`
+	Sources          []struct {
+		CompoundID string `json:"compoundId"`
+		ID         int    `json:"id"`
+		ShortName  string `json:"shortName"`
+	} `json:"sources"`
+}
+
+// mappings flattens the sources of comp into the src_id/src_compound_id
+// shape returned by the legacy endpoint.
+func (comp v1Compound) mappings() []map[string]string {
+	respMap := []map[string]string{}
+	for _, src := range comp.Sources {
+		respMap = append(respMap, map[string]string{
+			"src_id":          strconv.Itoa(src.ID),
+			"src_compound_id": src.CompoundID,
+			"src_name":        src.ShortName,
+		})
+	}
+	return respMap
+}
+
+// v1Connectivity is the subset of an api/v1/connectivity response that is
+// used.
+type v1Connectivity struct {
+	Sources []struct {
+		CompoundID string `json:"compoundId"`
+		ID         int    `json:"id"`
+		ShortName  string `json:"shortName"`
+	} `json:"sources"`
+}
+
+// compoundSources queries api/v1/compounds for a source ID and flattens the
+// linked sources into the same src_id/src_compound_id shape returned by the
+// legacy endpoint. It also returns the standard InChIKey of the compound and
+// the response body.
+//
+// The endpoint takes a single compound per request and neither UniChem API
+// offers a multi-compound lookup, so IDs cannot be batched; concurrent
+// callers sharing keep-alive connections are what keep large runs fast.
+func (c *Client) compoundSources(ctx context.Context, id, srcID string) ([]map[string]string, string, []byte, error) {
+	sourceID, err := strconv.Atoi(srcID)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("invalid src_id %q", srcID)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":     "sourceID",
+		"compound": id,
+		"sourceID": sourceID,
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	body, err := c.Fetch(ctx, "POST", JoinURL(c.BaseURL, DefaultURL, "/api/v1/compounds"), payload)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resp := v1Compounds{}
+	err = DecodeJSON(body, &resp)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	inchikey := ""
+	respMap := []map[string]string{}
+	for _, c := range resp.Compounds {
+		if inchikey == "" {
+			inchikey = c.StandardInChIKey
+		}
+		respMap = append(respMap, c.mappings()...)
+	}
+	return respMap, inchikey, body, nil
+}
+
+// get fetches url and decodes a legacy style list of src_id mappings.
+func (c *Client) get(ctx context.Context, url string) ([]map[string]string, error) {
+	respMap, _, err := c.getRaw(ctx, url)
+	return respMap, err
+}
+
+// getRaw is like get but also returns the response body.
+func (c *Client) getRaw(ctx context.Context, url string) ([]map[string]string, []byte, error) {
+	body, err := c.Fetch(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	respMap := []map[string]string{}
+	err = DecodeJSON(body, &respMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return respMap, body, nil
+}
@@ -0,0 +1,1032 @@
+package unichem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient returns a Client for the UniChem API api served by srv that
+// tries each request once.
+func testClient(srv *httptest.Server, api string) *Client {
+	return &Client{Fetcher: &Fetcher{HTTPClient: srv.Client()}, API: api, BaseURL: srv.URL}
+}
+
+// recorded returns the recorded response in testdata/name.
+func recorded(t *testing.T, name string) string {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// serveJSON returns a handler that answers every request with status and
+// body.
+func serveJSON(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+// lookup resolves chemblID against a server answering every request with
+// body.
+func lookup(t *testing.T, api, chemblID, body string) CompoundID {
+	t.Helper()
+	srv := httptest.NewServer(serveJSON(http.StatusOK, body))
+	defer srv.Close()
+
+	compound, err := testClient(srv, api).GetCompoundIDs(context.Background(), chemblID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compound
+}
+
+const legacyCHEMBL25 = `[
+	{"src_id": "2", "src_compound_id": "DB00945"},
+	{"src_id": "7", "src_compound_id": "15365"},
+	{"src_id": "22", "src_compound_id": "2244"}
+]`
+
+func TestGetCompoundIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    string
+		status int
+		body   string
+		want   CompoundID
+		// is, when set, must match the error with errors.Is; otherwise
+		// wantErr, when set, is a substring of it.
+		is      error
+		wantErr string
+	}{
+		{
+			name:   "success",
+			api:    "v1",
+			status: http.StatusOK,
+			body:   recorded(t, "v1_CHEMBL25.json"),
+			want:   CompoundID{ChEMBL: "CHEMBL25", PubChem: "2244", DrugBank: "DB00945", ChEBI: "CHEBI:15365"},
+		},
+		{
+			name:   "success legacy",
+			api:    "legacy",
+			status: http.StatusOK,
+			body:   legacyCHEMBL25,
+			want:   CompoundID{ChEMBL: "CHEMBL25", PubChem: "2244", DrugBank: "DB00945", ChEBI: "CHEBI:15365"},
+		},
+		{
+			name:   "not found",
+			api:    "v1",
+			status: http.StatusNotFound,
+			body:   `{"response": "Not found"}`,
+			want:   CompoundID{ChEMBL: "CHEMBL25"},
+			is:     ErrNotFound,
+		},
+		{
+			name:   "server error",
+			api:    "legacy",
+			status: http.StatusInternalServerError,
+			body:   `{"error": "internal"}`,
+			want:   CompoundID{ChEMBL: "CHEMBL25"},
+			is:     ErrTransient,
+		},
+		{
+			name:    "malformed JSON",
+			api:     "v1",
+			status:  http.StatusOK,
+			body:    `{"compounds": [{"sources": `,
+			want:    CompoundID{ChEMBL: "CHEMBL25"},
+			wantErr: "decoding response",
+		},
+		{
+			name:    "empty body",
+			api:     "legacy",
+			status:  http.StatusOK,
+			body:    "",
+			want:    CompoundID{ChEMBL: "CHEMBL25"},
+			wantErr: "empty response body",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(serveJSON(tt.status, tt.body))
+			defer srv.Close()
+
+			c := testClient(srv, tt.api)
+			c.Sources = map[string]bool{"2": true, "7": true, "22": true}
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			switch {
+			case tt.is != nil:
+				if !errors.Is(err, tt.is) {
+					t.Errorf("error = %v, want %v", err, tt.is)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+			if got.ChEMBL != tt.want.ChEMBL || got.PubChem != tt.want.PubChem || got.DrugBank != tt.want.DrugBank || got.ChEBI != tt.want.ChEBI {
+				t.Errorf("GetCompoundIDs = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     string
+		sources map[string]bool
+		method  string
+		path    string
+	}{
+		{"v1", "v1", nil, "POST", "/api/v1/compounds"},
+		// Several sources are answered at once by the _all endpoint.
+		{"legacy", "legacy", nil, "GET", "/rest/src_compound_id_all/CHEMBL25/1"},
+		{"legacy one source", "legacy", map[string]bool{"2": true}, "GET", "/rest/src_compound_id/CHEMBL25/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				serveJSON(http.StatusNotFound, "{}")(w, r)
+			}))
+			defer srv.Close()
+
+			c := testClient(srv, tt.api)
+			c.Sources = tt.sources
+			// A trailing slash on the base URL must not double up.
+			c.BaseURL += "/"
+			c.GetCompoundIDs(context.Background(), "chembl25")
+			if method != tt.method || path != tt.path {
+				t.Errorf("request = %s %s, want %s %s", method, path, tt.method, tt.path)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsKEGG(t *testing.T) {
+	tests := []struct {
+		name string
+		api  string
+		body string
+		want string
+		ids  []string
+	}{
+		{name: "mapped", api: "v1", body: recorded(t, "v1_CHEMBL25.json"), want: "D00109"},
+		{
+			name: "several ligands",
+			api:  "legacy",
+			body: `[{"src_id": "6", "src_compound_id": "D00109"}, {"src_id": "6", "src_compound_id": "C01405"}]`,
+			want: "C01405",
+			ids:  []string{"C01405", "D00109"},
+		},
+		{name: "not mapped", api: "legacy", body: legacyCHEMBL25, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lookup(t, tt.api, "CHEMBL25", tt.body)
+			if got.KEGG != tt.want {
+				t.Errorf("KEGG = %q, want %q", got.KEGG, tt.want)
+			}
+			if !slices.Equal(got.IDs["kegg"], tt.ids) {
+				t.Errorf("IDs[kegg] = %q, want %q", got.IDs["kegg"], tt.ids)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsNumericOrder(t *testing.T) {
+	body := `[
+		{"src_id": "22", "src_compound_id": "176155"},
+		{"src_id": "22", "src_compound_id": "2244"},
+		{"src_id": "34", "src_compound_id": "100"},
+		{"src_id": "34", "src_compound_id": "74"},
+		{"src_id": "7", "src_compound_id": "CHEBI:100"},
+		{"src_id": "7", "src_compound_id": "CHEBI:99"},
+		{"src_id": "6", "src_compound_id": "D00109"},
+		{"src_id": "6", "src_compound_id": "C01405"}
+	]`
+	got := lookup(t, "legacy", "CHEMBL25", body)
+	if got.PubChem != "2244" || got.DrugCentral != "74" || got.ChEBI != "CHEBI:99" {
+		t.Errorf("PubChem = %q, DrugCentral = %q, ChEBI = %q; want the lowest IDs 2244, 74 and CHEBI:99", got.PubChem, got.DrugCentral, got.ChEBI)
+	}
+	want := map[string][]string{
+		"pubchem":     {"2244", "176155"},
+		"drugcentral": {"74", "100"},
+		"chebi":       {"CHEBI:99", "CHEBI:100"},
+		"kegg":        {"C01405", "D00109"},
+	}
+	for field, ids := range want {
+		if !slices.Equal(got.IDs[field], ids) {
+			t.Errorf("IDs[%s] = %q, want %q", field, got.IDs[field], ids)
+		}
+	}
+}
+
+// fields returns the string fields of compound keyed by JSON name.
+func fields(t *testing.T, compound CompoundID) map[string]string {
+	t.Helper()
+	body, err := json.Marshal(compound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(body, &all); err != nil {
+		t.Fatal(err)
+	}
+	strs := map[string]string{}
+	for k, v := range all {
+		if s, ok := v.(string); ok {
+			strs[k] = s
+		}
+	}
+	return strs
+}
+
+// TestGetCompoundIDsSources checks the field each source fills, from the
+// recorded aspirin response unless a case gives its own legacy one.
+func TestGetCompoundIDsSources(t *testing.T) {
+	aspirin := recorded(t, "v1_CHEMBL25.json")
+	tests := []struct {
+		name  string
+		body  string
+		field string
+		want  string
+	}{
+		{name: "drugcentral", field: "drugcentral", want: "74"},
+		// A compound DrugCentral does not list.
+		{name: "drugcentral absent", body: legacyCHEMBL25, field: "drugcentral", want: ""},
+		{name: "bindingdb", field: "bindingdb", want: "22360"},
+		{name: "gtopdb", field: "gtopdb", want: "4139"},
+		// A compound without a Guide to Pharmacology ligand.
+		{name: "gtopdb absent", body: legacyCHEMBL25, field: "gtopdb", want: ""},
+		{name: "hmdb", field: "hmdb", want: "HMDB0001879"},
+		{name: "unii", field: "fdasrs", want: "R16CO5Y76E"},
+		// The recorded response lists src_id 17 among twenty other sources.
+		{name: "pharmgkb", field: "pharmgkb", want: "PA448497"},
+		{name: "zinc", field: "zinc", want: "ZINC000000000053"},
+		{
+			name:  "zinc legacy",
+			body:  `[{"src_id": "9", "src_compound_id": "ZINC000000000053"}, {"src_id": "22", "src_compound_id": "2244"}]`,
+			field: "zinc",
+			want:  "ZINC000000000053",
+		},
+		{name: "comptox", field: "comptox", want: "DTXSID5020108"},
+		{
+			name:  "lipidmaps",
+			body:  `[{"src_id": "33", "src_compound_id": "LMFA03010003"}, {"src_id": "22", "src_compound_id": "5280723"}]`,
+			field: "lipidmaps",
+			want:  "LMFA03010003",
+		},
+		// Aspirin is not a lipid.
+		{name: "lipidmaps absent", field: "lipidmaps", want: ""},
+		// DailyMed set IDs are UUIDs and are kept verbatim.
+		{name: "dailymed", field: "dailymed", want: "1d18a906-8c5c-43a4-9a77-a996b1d90c7f"},
+		{
+			name:  "dailymed legacy",
+			body:  `[{"src_id": "45", "src_compound_id": "1D18A906-8C5C-43A4-9A77-A996B1D90C7F"}]`,
+			field: "dailymed",
+			want:  "1D18A906-8C5C-43A4-9A77-A996B1D90C7F",
+		},
+		{name: "surechembl", field: "surechembl", want: "SCHEMBL1353"},
+		// A compound without a patent mapping.
+		{
+			name:  "surechembl absent",
+			body:  legacyCHEMBL25,
+			field: "surechembl",
+			want:  "",
+		},
+		{name: "pdb", field: "pdb", want: "AIN"},
+		{
+			name:  "pdb legacy",
+			body:  `[{"src_id": "3", "src_compound_id": "STI"}, {"src_id": "22", "src_compound_id": "5291"}]`,
+			field: "pdb",
+			want:  "STI",
+		},
+		{name: "emolecules", field: "emolecules", want: "477512"},
+		// eMolecules IDs are numbers, so the lowest comes first.
+		{
+			name:  "emolecules legacy",
+			body:  `[{"src_id": "10", "src_compound_id": "31171690"}, {"src_id": "10", "src_compound_id": "477512"}]`,
+			field: "emolecules",
+			want:  "477512",
+		},
+		{name: "molport", field: "molport", want: "MolPort-000-871-563"},
+		{
+			name:  "molport legacy",
+			body:  `[{"src_id": "28", "src_compound_id": "MolPort-000-871-563"}, {"src_id": "28", "src_compound_id": "MolPort-001-783-180"}]`,
+			field: "molport",
+			want:  "MolPort-000-871-563",
+		},
+		{name: "lincs", field: "lincs", want: "LSM-5288"},
+		// A compound LINCS never profiled.
+		{
+			name:  "lincs absent",
+			body:  legacyCHEMBL25,
+			field: "lincs",
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, body := "v1", aspirin
+			if tt.body != "" {
+				api, body = "legacy", tt.body
+			}
+			got := fields(t, lookup(t, api, "CHEMBL25", body))
+			if got[tt.field] != tt.want {
+				t.Errorf("%s = %q, want %q", tt.field, got[tt.field], tt.want)
+			}
+			if _, ok := got[tt.field]; ok && tt.want == "" {
+				t.Errorf("marshalled compound has a %q key, want it omitted", tt.field)
+			}
+		})
+	}
+}
+
+func TestCompoundIDJSONRoundTrip(t *testing.T) {
+	compound := lookup(t, "v1", "CHEMBL25", recorded(t, "v1_CHEMBL25.json"))
+	body, err := json.Marshal(compound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := CompoundID{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.HMDB != "HMDB0001879" || !strings.Contains(string(body), `"hmdb":"HMDB0001879"`) {
+		t.Errorf("HMDB after a round trip = %q in %s", decoded.HMDB, body)
+	}
+}
+
+func TestGetCompoundIDsBySource(t *testing.T) {
+	tests := []struct {
+		api  string
+		body string
+		// request is the path, and for v1 the payload, the lookup must send.
+		request string
+	}{
+		{api: "v1", body: recorded(t, "v1_CHEMBL25.json"), request: `/api/v1/compounds {"compound":"2244","sourceID":22,"type":"sourceID"}`},
+		{
+			api:     "legacy",
+			body:    `[{"src_id": "1", "src_compound_id": "CHEMBL25"}, {"src_id": "2", "src_compound_id": "DB00945"}]`,
+			request: "/rest/src_compound_id_all/2244/22 ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.api, func(t *testing.T) {
+			var request string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				payload, _ := io.ReadAll(r.Body)
+				request = r.URL.Path + " " + string(payload)
+				serveJSON(http.StatusOK, tt.body)(w, r)
+			}))
+			defer srv.Close()
+
+			got, err := testClient(srv, tt.api).GetCompoundIDsBySource(context.Background(), "2244", "22")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if request != tt.request {
+				t.Errorf("request = %q, want %q", request, tt.request)
+			}
+			if got.ChEMBL != "CHEMBL25" || got.DrugBank != "DB00945" || got.PubChem != "2244" {
+				t.Errorf("GetCompoundIDsBySource = %+v, want CHEMBL25, DB00945 and the queried CID 2244", got)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsByInChIKey(t *testing.T) {
+	const inchikey = "BSYNRYMUTXBXSQ-UHFFFAOYSA-N"
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		serveJSON(http.StatusOK, `[
+			{"src_id": "1", "src_compound_id": "CHEMBL25"},
+			{"src_id": "2", "src_compound_id": "DB00945"},
+			{"src_id": "7", "src_compound_id": "15365"},
+			{"src_id": "22", "src_compound_id": "2244"}
+		]`)(w, r)
+	}))
+	defer srv.Close()
+
+	c := testClient(srv, "legacy")
+	c.WithStructure = true
+	got, err := c.GetCompoundIDsByInChIKey(context.Background(), inchikey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/rest/inchikey/"+inchikey {
+		t.Errorf("path = %q", path)
+	}
+	want := CompoundID{ChEMBL: "CHEMBL25", DrugBank: "DB00945", ChEBI: "CHEBI:15365", PubChem: "2244", InChIKey: inchikey}
+	if got.ChEMBL != want.ChEMBL || got.DrugBank != want.DrugBank || got.ChEBI != want.ChEBI || got.PubChem != want.PubChem || got.InChIKey != want.InChIKey {
+		t.Errorf("GetCompoundIDsByInChIKey = %+v, want %+v", got, want)
+	}
+
+	srv.Config.Handler = serveJSON(http.StatusNotFound, `{"error": "not found"}`)
+	if _, err := c.GetCompoundIDsByInChIKey(context.Background(), inchikey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown InChIKey error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNormalizeChEMBLID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "CHEMBL25", want: "CHEMBL25"},
+		{id: "chembl12", want: "CHEMBL12"},
+		{id: "  CheMBL941\t", want: "CHEMBL941"},
+		{id: "25", want: "CHEMBL25"},
+		{id: " 941 ", want: "CHEMBL941"},
+		{id: "", wantErr: true},
+		{id: "CHEMBL", wantErr: true},
+		{id: "CHEMBL25X", wantErr: true},
+		{id: "DB00945", wantErr: true},
+		{id: "-25", wantErr: true},
+		{id: "CHEMBL 25", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeChEMBLID(tt.id)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "ChEMBL ID") {
+				t.Errorf("NormalizeChEMBLID(%q) = %q, %v; want a descriptive error", tt.id, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeChEMBLID(%q) = %q, %v; want %q", tt.id, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDrugBankID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "DB00945", want: "DB00945"},
+		{id: "945", want: "DB00945"},
+		{id: " db00316 ", want: "DB00316"},
+		{id: "DB945", want: "DB00945"},
+		{id: "", wantErr: true},
+		{id: "DB", wantErr: true},
+		{id: "DB123456", wantErr: true},
+		{id: "DBSALT000001", wantErr: true},
+		{id: "CHEMBL25", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeDrugBankID(tt.id)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "DrugBank ID") {
+				t.Errorf("NormalizeDrugBankID(%q) = %q, %v; want a descriptive error", tt.id, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeDrugBankID(%q) = %q, %v; want %q", tt.id, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetCompoundIDsDrugBankForms(t *testing.T) {
+	tests := []struct {
+		name, id, want string
+		logged         bool
+	}{
+		{name: "canonical", id: "DB00945", want: "DB00945"},
+		{name: "bare numeric", id: "945", want: "DB00945"},
+		{name: "invalid", id: "DB-945", want: "DB-945", logged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(serveJSON(http.StatusOK, `[{"src_id": "2", "src_compound_id": "`+tt.id+`"}]`))
+			defer srv.Close()
+			logs := &strings.Builder{}
+			c := testClient(srv, "legacy")
+			c.Logger = slog.New(slog.NewTextHandler(logs, nil))
+
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.DrugBank != tt.want {
+				t.Errorf("DrugBank = %q, want %q", got.DrugBank, tt.want)
+			}
+			if logged := strings.Contains(logs.String(), "invalid DrugBank ID"); logged != tt.logged {
+				t.Errorf("logs =\n%s\nwant a DrugBank warning: %t", logs, tt.logged)
+			}
+		})
+	}
+}
+
+func TestNormalizeChEBIID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "CHEBI:15377", want: "CHEBI:15377"},
+		{id: "15377", want: "CHEBI:15377"},
+		{id: " chebi:15377 ", want: "CHEBI:15377"},
+		{id: "CHEBI:015377", want: "CHEBI:15377"},
+		{id: "", wantErr: true},
+		{id: "CHEBI:", wantErr: true},
+		{id: "CHEBI:0", wantErr: true},
+		{id: "CHEBI:15377a", wantErr: true},
+		{id: "CHEBI15377", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeChEBIID(tt.id)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "ChEBI ID") {
+				t.Errorf("NormalizeChEBIID(%q) = %q, %v; want a descriptive error", tt.id, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeChEBIID(%q) = %q, %v; want %q", tt.id, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetCompoundIDsChEBIForms(t *testing.T) {
+	tests := []struct {
+		name, id, want string
+		logged         bool
+	}{
+		{name: "prefixed", id: "CHEBI:15365", want: "CHEBI:15365"},
+		{name: "bare", id: "15365", want: "CHEBI:15365"},
+		{name: "invalid", id: "CHEBI:x15365", want: "CHEBI:x15365", logged: true},
+	}
+	bodies := map[string]func(id string) string{
+		"v1": func(id string) string {
+			return `{"compounds": [{"sources": [{"compoundId": "` + id + `", "id": 7, "shortName": "chebi"}]}]}`
+		},
+		"legacy": func(id string) string {
+			return `[{"src_id": "7", "src_compound_id": "` + id + `"}]`
+		},
+	}
+	for _, api := range []string{"v1", "legacy"} {
+		for _, tt := range tests {
+			t.Run(api+" "+tt.name, func(t *testing.T) {
+				srv := httptest.NewServer(serveJSON(http.StatusOK, bodies[api](tt.id)))
+				defer srv.Close()
+				logs := &strings.Builder{}
+				c := testClient(srv, api)
+				c.Logger = slog.New(slog.NewTextHandler(logs, nil))
+
+				got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.ChEBI != tt.want {
+					t.Errorf("ChEBI = %q, want %q", got.ChEBI, tt.want)
+				}
+				if logged := strings.Contains(logs.String(), "invalid ChEBI ID"); logged != tt.logged {
+					t.Errorf("logs =\n%s\nwant a ChEBI warning: %t", logs, tt.logged)
+				}
+			})
+		}
+	}
+}
+
+func TestGetCompoundIDsInvalidID(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		serveJSON(http.StatusOK, "[]")(w, r)
+	}))
+	defer srv.Close()
+
+	for _, id := range []string{"", "aspirin"} {
+		if _, err := testClient(srv, "v1").GetCompoundIDs(context.Background(), id); err == nil {
+			t.Errorf("GetCompoundIDs(%q) succeeded", id)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("sent %d requests for invalid IDs, want none", requests)
+	}
+}
+
+func TestGetCompoundIDsAllSources(t *testing.T) {
+	tests := []struct {
+		name string
+		api  string
+		want map[string]string
+	}{
+		// The v1 API names each source inline.
+		{name: "v1", api: "v1", want: map[string]string{"chembl": "CHEMBL25", "drugbank": "DB00945", "newdb": "ND-0001"}},
+		// The legacy API is named from rest/sources, which does not know
+		// src_id 99 yet, so it is keyed by number.
+		{name: "legacy", api: "legacy", want: map[string]string{"chembl": "CHEMBL25", "drugbank": "DB00945", "99": "ND-0001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v1/compounds":
+					serveJSON(http.StatusOK, `{"compounds": [{"sources": [
+						{"compoundId": "CHEMBL25", "id": 1, "shortName": "chembl"},
+						{"compoundId": "DB00945", "id": 2, "shortName": "drugbank"},
+						{"compoundId": "ND-0001", "id": 99, "shortName": "newdb"}
+					]}]}`)(w, r)
+				case "/rest/src_compound_id_all/CHEMBL25/1":
+					serveJSON(http.StatusOK, `[{"src_id": "2", "src_compound_id": "DB00945"}, {"src_id": "99", "src_compound_id": "ND-0001"}]`)(w, r)
+				case "/rest/src_ids/":
+					serveJSON(http.StatusOK, `[{"src_id": "1"}, {"src_id": "2"}]`)(w, r)
+				case "/rest/sources/1":
+					serveJSON(http.StatusOK, `[{"src_id": "1", "name": "chembl"}]`)(w, r)
+				case "/rest/sources/2":
+					serveJSON(http.StatusOK, `[{"src_id": "2", "name": "drugbank"}]`)(w, r)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			c := testClient(srv, tt.api)
+			c.AllSources = true
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got.AllSources, tt.want) {
+				t.Errorf("AllSources = %v, want %v", got.AllSources, tt.want)
+			}
+			if got.DrugBank != "DB00945" {
+				t.Errorf("DrugBank = %q; typed fields must still be filled", got.DrugBank)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsSeveralPerSource(t *testing.T) {
+	// The order of the mappings in the response must not matter.
+	bodies := []string{
+		recorded(t, "legacy_two_drugbank.json"),
+		`[{"src_id": "2", "src_compound_id": "DB00945"}, {"src_id": "2", "src_compound_id": "DB01234"}, {"src_id": "22", "src_compound_id": "2244"}]`,
+	}
+	for _, body := range bodies {
+		got := lookup(t, "legacy", "CHEMBL25", body)
+		if got.DrugBank != "DB00945" {
+			t.Errorf("DrugBank = %q, want the first in sorted order, DB00945", got.DrugBank)
+		}
+		if want := []string{"DB00945", "DB01234"}; !slices.Equal(got.IDs["drugbank"], want) {
+			t.Errorf("IDs[drugbank] = %q, want %q", got.IDs["drugbank"], want)
+		}
+		// A source with one ID is not listed.
+		if _, ok := got.IDs["pubchem"]; ok || got.PubChem != "2244" {
+			t.Errorf("PubChem = %q with IDs %q, want 2244 alone", got.PubChem, got.IDs["pubchem"])
+		}
+	}
+}
+
+func TestGetCompoundIDsObsolete(t *testing.T) {
+	body := recorded(t, "legacy_all_CHEMBL25.json")
+	for _, include := range []bool{false, true} {
+		t.Run(fmt.Sprint(include), func(t *testing.T) {
+			srv := httptest.NewServer(serveJSON(http.StatusOK, body))
+			defer srv.Close()
+
+			c := testClient(srv, "legacy")
+			c.IncludeObsolete = include
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Retired mappings must not fill the fields or IDs either way.
+			if got.DrugBank != "DB00945" || got.PubChem != "2244" || got.IDs != nil {
+				t.Errorf("GetCompoundIDs = %+v, want DB00945 and 2244 alone", got)
+			}
+			var want map[string][]string
+			if include {
+				want = map[string][]string{"drugbank": {"DB01399"}, "pubchem": {"517180"}}
+			}
+			if !reflect.DeepEqual(got.Obsolete, want) {
+				t.Errorf("Obsolete = %v, want %v", got.Obsolete, want)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsKeepRaw(t *testing.T) {
+	tests := []struct {
+		api  string
+		body string
+	}{
+		{"v1", recorded(t, "v1_CHEMBL25.json")},
+		{"legacy", recorded(t, "legacy_two_drugbank.json")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.api, func(t *testing.T) {
+			srv := httptest.NewServer(serveJSON(http.StatusOK, tt.body))
+			defer srv.Close()
+
+			c := testClient(srv, tt.api)
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Raw != nil {
+				t.Errorf("Raw = %s without KeepRaw, want none", got.Raw)
+			}
+			c.KeepRaw = true
+			got, err = c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The body is kept byte for byte, whitespace included.
+			if string(got.Raw) != tt.body {
+				t.Errorf("Raw = %s, want the response verbatim:\n%s", got.Raw, tt.body)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsErrors(t *testing.T) {
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	tests := []struct {
+		name      string
+		url       string
+		status    int
+		notFound  bool
+		transient bool
+	}{
+		{name: "404", status: http.StatusNotFound, notFound: true},
+		{name: "500", status: http.StatusInternalServerError, transient: true},
+		{name: "400", status: http.StatusBadRequest},
+		{name: "connection refused", url: refused.URL, transient: true},
+	}
+	for _, api := range []string{"v1", "legacy"} {
+		for _, tt := range tests {
+			t.Run(api+" "+tt.name, func(t *testing.T) {
+				srv := httptest.NewServer(serveJSON(tt.status, "{}"))
+				defer srv.Close()
+				c := testClient(srv, api)
+				if tt.url != "" {
+					c.BaseURL = tt.url
+				}
+
+				_, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+				if err == nil {
+					t.Fatal("lookup succeeded")
+				}
+				if errors.Is(err, ErrNotFound) != tt.notFound || errors.Is(err, ErrTransient) != tt.transient {
+					t.Errorf("error %v: errors.Is ErrNotFound = %t, ErrTransient = %t, want %t and %t", err, errors.Is(err, ErrNotFound), errors.Is(err, ErrTransient), tt.notFound, tt.transient)
+				}
+				var status *StatusError
+				if errors.As(err, &status) != (tt.status != 0) {
+					t.Errorf("error %v: errors.As StatusError = %t, want %t", err, status != nil, tt.status != 0)
+				} else if status != nil && status.Code != tt.status {
+					t.Errorf("StatusError.Code = %d, want %d", status.Code, tt.status)
+				}
+			})
+		}
+	}
+
+	// Cancelling the lookup is not a transient failure.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	srv := httptest.NewServer(serveJSON(http.StatusOK, "{}"))
+	defer srv.Close()
+	_, err := testClient(srv, "v1").GetCompoundIDs(ctx, "CHEMBL25")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTransient) {
+		t.Errorf("cancelled lookup = %v, want context.Canceled and not ErrTransient", err)
+	}
+}
+
+func TestGetCompoundIDsPDBLigands(t *testing.T) {
+	// A compound can have several ligand codes.
+	got := lookup(t, "legacy", "CHEMBL941", `[{"src_id": "3", "src_compound_id": "STI"}, {"src_id": "3", "src_compound_id": "MPZ"}]`)
+	if got.PDB != "MPZ" {
+		t.Errorf("PDB = %q, want the first in sorted order, MPZ", got.PDB)
+	}
+	if want := []string{"MPZ", "STI"}; !slices.Equal(got.IDs["pdb"], want) {
+		t.Errorf("IDs[pdb] = %q, want %q", got.IDs["pdb"], want)
+	}
+}
+
+func TestGetCompoundIDsClinicalTrials(t *testing.T) {
+	tests := []struct {
+		name string
+		api  string
+		body string
+		want string
+		ids  []string
+	}{
+		{name: "single", api: "legacy", body: `[{"src_id": "46", "src_compound_id": "IMATINIB"}]`, want: "IMATINIB"},
+		// Aspirin is listed under two names in the recorded response.
+		{name: "multiple", api: "v1", body: recorded(t, "v1_CHEMBL25.json"), want: "ACETYLSALICYLIC ACID", ids: []string{"ACETYLSALICYLIC ACID", "ASPIRIN"}},
+		{name: "none", api: "legacy", body: legacyCHEMBL25, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lookup(t, tt.api, "CHEMBL25", tt.body)
+			if got.ClinicalTrials != tt.want {
+				t.Errorf("ClinicalTrials = %q, want %q", got.ClinicalTrials, tt.want)
+			}
+			if !slices.Equal(got.IDs["clinicaltrials"], tt.ids) {
+				t.Errorf("IDs[clinicaltrials] = %q, want %q", got.IDs["clinicaltrials"], tt.ids)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsWithStructure(t *testing.T) {
+	const aspirin = "BSYNRYMUTXBXSQ-UHFFFAOYSA-N"
+	tests := []struct {
+		name          string
+		api           string
+		withStructure bool
+		structure     string
+		want          string
+	}{
+		{name: "v1", api: "v1", withStructure: true, want: aspirin},
+		{name: "v1 not requested", api: "v1", want: ""},
+		{name: "legacy", api: "legacy", withStructure: true, structure: `[{"standardinchi": "InChI=1S/C9H8O4/...", "standardinchikey": "` + aspirin + `"}]`, want: aspirin},
+		// Some compounds, such as biologics, have no structure.
+		{name: "legacy without structure", api: "legacy", withStructure: true, structure: `[]`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			structures := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/api/v1/compounds":
+					serveJSON(http.StatusOK, recorded(t, "v1_CHEMBL25.json"))(w, r)
+				case r.URL.Path == "/rest/structure/CHEMBL25/1":
+					structures++
+					serveJSON(http.StatusOK, tt.structure)(w, r)
+				default:
+					serveJSON(http.StatusOK, legacyCHEMBL25)(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			c := testClient(srv, tt.api)
+			c.WithStructure = tt.withStructure
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.InChIKey != tt.want {
+				t.Errorf("InChIKey = %q, want %q", got.InChIKey, tt.want)
+			}
+			// Only the legacy API needs a request for the structure.
+			want := 0
+			if tt.structure != "" {
+				want = 1
+			}
+			if structures != want {
+				t.Errorf("fetched the structure %d times, want %d", structures, want)
+			}
+		})
+	}
+}
+
+func TestGetCompoundIDsSourceVersions(t *testing.T) {
+	aspirin := recorded(t, "v1_CHEMBL25.json")
+	var sourceLists int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/sources/" {
+			sourceLists++
+			serveJSON(http.StatusOK, `{"sources": [
+				{"sourceID": 1, "srcReleaseNumber": 33, "srcReleaseDate": "2023-05-31"},
+				{"sourceID": 2, "srcReleaseNumber": "5.1.10", "srcReleaseDate": "2023-01-04"},
+				{"sourceID": 4, "srcReleaseNumber": 2023.2},
+				{"sourceID": 7, "srcReleaseNumber": null},
+				{"sourceID": 22, "srcReleaseDate": "2023-06-12"}
+			]}`)(w, r)
+			return
+		}
+		serveJSON(http.StatusOK, aspirin)(w, r)
+	}))
+	defer srv.Close()
+
+	for _, meta := range []bool{false, true} {
+		c := testClient(srv, "v1")
+		c.Sources, _ = ParseSources("2,7,22")
+		c.WithSourceMeta = meta
+		for i := 0; i < 2; i++ {
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want map[string]string
+			if meta {
+				// ChEBI has no release listed, and GtoPdb is not selected.
+				want = map[string]string{"chembl": "33", "drugbank": "5.1.10", "pubchem": "2023-06-12"}
+			}
+			if !reflect.DeepEqual(got.SourceVersions, want) {
+				t.Errorf("WithSourceMeta=%t: SourceVersions = %v, want %v", meta, got.SourceVersions, want)
+			}
+		}
+	}
+	if sourceLists != 1 {
+		t.Errorf("fetched the source list %d times, want once", sourceLists)
+	}
+}
+
+func TestGetConnectivity(t *testing.T) {
+	var payload map[string]interface{}
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&payload)
+		serveJSON(http.StatusOK, recorded(t, "v1_connectivity_BSYNRYMUTXBXSQ.json"))(w, r)
+	}))
+	defer srv.Close()
+
+	// The legacy API has no connectivity search of its own.
+	got, err := testClient(srv, "legacy").GetConnectivity(context.Background(), "BSYNRYMUTXBXSQ-UHFFFAOYSA-N")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/v1/connectivity" || payload["type"] != "inchikey" || payload["compound"] != "BSYNRYMUTXBXSQ-UHFFFAOYSA-N" {
+		t.Errorf("sent %v to %s, want an inchikey search of api/v1/connectivity", payload, path)
+	}
+	want := map[string][]string{
+		"chembl":   {"CHEMBL2296002", "CHEMBL25"},
+		"drugbank": {"DB00945"},
+		"pubchem":  {"16213837", "2244"},
+		// Sources without a short name are keyed by src_id.
+		"3": {"AIN"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetConnectivity = %v, want %v", got, want)
+	}
+}
+
+func TestGetCompoundIDsEmptyRetries(t *testing.T) {
+	sparse := `[{"src_id": "22", "src_compound_id": "2244"}]`
+	tests := []struct {
+		name        string
+		api         string
+		bodies      []string
+		retries     int
+		minMappings int
+		// requests is how many lookups are sent, and pubchem and drugbank
+		// what the last one resolves.
+		requests int
+		pubchem  string
+		drugbank string
+	}{
+		{name: "trusted", api: "legacy", bodies: []string{"[]", legacyCHEMBL25}, requests: 1},
+		{name: "retried", api: "legacy", bodies: []string{"[]", "[]", legacyCHEMBL25}, retries: 2, requests: 3, pubchem: "2244", drugbank: "DB00945"},
+		{name: "still empty", api: "legacy", bodies: []string{"[]", "[]", legacyCHEMBL25}, retries: 1, requests: 2},
+		{name: "v1", api: "v1", bodies: []string{`{"compounds": []}`, recorded(t, "v1_CHEMBL25.json")}, retries: 3, requests: 2, pubchem: "2244", drugbank: "DB00945"},
+		{name: "sparse", api: "legacy", bodies: []string{sparse, legacyCHEMBL25}, retries: 1, minMappings: 3, requests: 2, pubchem: "2244", drugbank: "DB00945"},
+		{name: "sparse below threshold", api: "legacy", bodies: []string{sparse, legacyCHEMBL25}, retries: 1, requests: 1, pubchem: "2244"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := tt.bodies[min(requests, len(tt.bodies)-1)]
+				requests++
+				serveJSON(http.StatusOK, body)(w, r)
+			}))
+			defer srv.Close()
+
+			c := testClient(srv, tt.api)
+			c.Backoff = time.Millisecond
+			c.EmptyRetries = tt.retries
+			c.MinMappings = tt.minMappings
+			got, err := c.GetCompoundIDs(context.Background(), "CHEMBL25")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requests != tt.requests || got.PubChem != tt.pubchem || got.DrugBank != tt.drugbank {
+				t.Errorf("%d requests resolved PubChem %q and DrugBank %q, want %d resolving %q and %q",
+					requests, got.PubChem, got.DrugBank, tt.requests, tt.pubchem, tt.drugbank)
+			}
+		})
+	}
+}